@@ -1,58 +1,75 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"streamwatcher/common"
+	_ "streamwatcher/helpers/discord"
+	"streamwatcher/helpers/downloader"
+	"streamwatcher/helpers/email"
+	"streamwatcher/helpers/jobstore"
+	"streamwatcher/helpers/notifier"
+	_ "streamwatcher/helpers/streamlink"
+	_ "streamwatcher/helpers/telegram"
+	_ "streamwatcher/helpers/webhook"
 	"streamwatcher/helpers/webserver"
+	_ "streamwatcher/helpers/ytarchive"
+	_ "streamwatcher/helpers/ytdlp"
+	"streamwatcher/provider"
 	"streamwatcher/provider/twitch"
 	"streamwatcher/provider/youtube"
-	"sync"
+	"strings"
+	"syscall"
 	"time"
 
 	"streamwatcher/config"
 
 	"github.com/kataras/golog"
+	"golang.org/x/crypto/bcrypt"
 )
 
-var (
-	twitchMutex  sync.Mutex
-	youtubeMutex sync.Mutex
-)
+func initialized() {
+	golog.Debug("[System] Creating directories if not exists")
+	createDirIfNotExist("temp")
+	createDirIfNotExist("downloads")
 
-func safeTwitchCheck() {
-	if !twitchMutex.TryLock() {
-		golog.Debug("[System] Twitch checker is already running")
-		return
+	database := config.Get().Archive.Database
+	if database == "" {
+		database = "data/streamwatcher.db"
 	}
-	defer twitchMutex.Unlock()
-	golog.Debug("[System] Running Twitch check")
-	twitch.CheckLiveAllChannel()
-}
-
-func safeYouTubeCheck() {
-	if !youtubeMutex.TryLock() {
-		golog.Debug("[System] YouTube checker is already running")
-		return
+	createDirIfNotExist(filepath.Dir(database))
+	golog.Debug("[System] Opening job store: ", database)
+	store, err := jobstore.Open(database)
+	if err != nil {
+		golog.Fatal("Failed to open job store: ", err)
+	}
+	if err := common.LoadDownloadJobs(store); err != nil {
+		golog.Fatal("Failed to load jobs from store: ", err)
 	}
-	defer youtubeMutex.Unlock()
-	golog.Debug("[System] Running YouTube check")
-	youtube.CheckLiveAllChannel()
 }
 
-func archivers() {
-	golog.Debug("[System] Scheduled check for live channels")
-	if config.AppConfig.Archive.YouTube {
-		safeYouTubeCheck()
+// shutdown waits for the running downloads to finalize and persists the jobs
+func shutdown() {
+	timeout := time.Duration(config.Get().Archive.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		// Leave room for the notifications within the 5m stop_grace_period
+		// of docker-compose.yml
+		timeout = 4 * time.Minute
 	}
-	if config.AppConfig.Archive.Twitch {
-		safeTwitchCheck()
+	golog.Info("[System] Shutting down, waiting up to ", timeout, " for running downloads")
+	if !downloader.Wait(timeout) {
+		golog.Warn("[System] Timed out waiting for downloads, killed the remaining ones")
 	}
-}
-
-func initialized() {
-	golog.Debug("[System] Creating directories if not exists")
-	createDirIfNotExist("temp")
-	createDirIfNotExist("downloads")
+	if !notifier.Wait(30 * time.Second) {
+		golog.Warn("[System] Timed out sending the remaining notifications")
+	}
+	common.CloseStore()
+	golog.Info("[System] Stopped")
 }
 
 func createDirIfNotExist(dir string) {
@@ -62,22 +79,50 @@ func createDirIfNotExist(dir string) {
 	}
 }
 
+// hashPassword prints the bcrypt hash of the password read from stdin, for
+// the password_hash of an [[auth.user]]
+func hashPassword() {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		golog.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(password, "\r\n")), bcrypt.DefaultCost)
+	if err != nil {
+		golog.Fatal(err)
+	}
+	fmt.Println(string(hash))
+}
+
 func main() {
-	config.LoadConfig()
-	go webserver.StartServer()
 	debug := flag.Bool("debug", false, "enable debug mode")
+	hash := flag.Bool("hash-password", false, "print the bcrypt hash of a password read from stdin and exit")
 	flag.Parse()
+	if *hash {
+		hashPassword()
+		return
+	}
+	config.LoadConfig()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go webserver.StartServer(ctx)
 	if *debug {
 		golog.SetLevel("debug")
 	}
 	golog.Infof("[System] Starting...")
 	initialized()
 
-	archivers() // Initial check at startup
-
-	ticker := time.NewTicker(time.Duration(config.AppConfig.Archive.Checker) * time.Minute)
-	defer ticker.Stop()
-	for range ticker.C {
-		archivers()
+	if config.Get().WebSub.Enabled {
+		go youtube.StartWebSub(ctx)
 	}
+	if config.Get().Twitch.EventSub {
+		go twitch.StartEventSub(ctx)
+	}
+
+	go email.StartDigest(ctx)
+
+	provider.RunScheduler(ctx)
+	// Restore the default signal handling, so a second Ctrl-C exits at once
+	stop()
+	shutdown()
 }
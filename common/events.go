@@ -0,0 +1,91 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	JobCreated  = "created"
+	JobState    = "state"
+	JobProgress = "progress"
+	JobUpdated  = "updated"
+	JobFinished = "finished"
+	JobDeleted  = "deleted"
+)
+
+// JobEvent is a change of a download job, Job is a snapshot taken when it happened
+type JobEvent struct {
+	Type string
+	Job  *DownloadJob
+	At   time.Time
+}
+
+var (
+	subscribers     = make(map[chan JobEvent]struct{})
+	subscribersLock sync.Mutex
+	// progressInterval limits how often a job publishes its progress
+	progressInterval = time.Second
+)
+
+// Subscribe returns a channel receiving every job event and a function to
+// stop the subscription. Events are dropped for subscribers that fall behind.
+func Subscribe() (<-chan JobEvent, func()) {
+	ch := make(chan JobEvent, 64)
+	subscribersLock.Lock()
+	subscribers[ch] = struct{}{}
+	subscribersLock.Unlock()
+
+	return ch, func() {
+		subscribersLock.Lock()
+		delete(subscribers, ch)
+		subscribersLock.Unlock()
+	}
+}
+
+// publish sends an event to the subscribers without blocking.
+// The caller must hold DownloadJobsLock.
+func publish(eventType string, job *DownloadJob) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+	event := JobEvent{Type: eventType, Job: job.Snapshot(), At: time.Now().UTC()}
+	for ch := range subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// PublishProgress publishes the progress of a job parsed from the output of
+// its downloader, at most once per progressInterval
+func PublishProgress(videoID string) {
+	DownloadJobsLock.Lock()
+	defer DownloadJobsLock.Unlock()
+
+	job, exists := DownloadJobs[videoID]
+	if !exists || time.Since(job.progressPublished) < progressInterval {
+		return
+	}
+	job.progressPublished = time.Now()
+	publish(JobProgress, job)
+}
+
+// Snapshot copies a job so it can be read after the lock is released.
+// The caller must hold DownloadJobsLock.
+func (job *DownloadJob) Snapshot() *DownloadJob {
+	snapshot := *job
+	snapshot.Process = nil
+	snapshot.StatusHistory = append([]StatusChange(nil), job.StatusHistory...)
+	if job.NotificationMessages != nil {
+		snapshot.NotificationMessages = make(map[string]string, len(job.NotificationMessages))
+		for name, id := range job.NotificationMessages {
+			snapshot.NotificationMessages[name] = id
+		}
+	}
+	return &snapshot
+}
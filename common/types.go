@@ -1,6 +1,12 @@
 package common
 
-import "sync"
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
 
 type ChannelLive struct {
 	Title          string
@@ -11,6 +17,18 @@ type ChannelLive struct {
 	ChannelPicture string
 	DateCrawled    string
 	MembersOnly    bool
+	Provider       string
+	Upcoming       bool
+	ScheduledStart time.Time
+	// VOD marks a recording of a past stream, ParentID is the live stream it
+	// belongs to
+	VOD      bool
+	ParentID string
+}
+
+type StatusChange struct {
+	Status string
+	At     time.Time
 }
 
 type DownloadJob struct {
@@ -23,13 +41,135 @@ type DownloadJob struct {
 	TotalSize      string
 	OutPath        string
 	FinalFile      string
+	StatusHistory  []StatusChange
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	EndedAt        time.Time
+	Downloader     string
+	Attempts       int
+	LastError      string
+	// NotificationMessages are the ids of the messages notifiers keep
+	// editing, by notifier name
+	NotificationMessages map[string]string
+	// Process is the running downloader, nil once it exited
+	Process *os.Process `json:"-"`
+	Stopped bool        `json:"-"`
+	Deleted bool        `json:"-"`
+	// Finalizing is set from the exit of the process until its final status
+	// is saved
+	Finalizing bool `json:"-"`
+
+	progressPublished time.Time
+}
+
+// JobStore persists download jobs so they survive restarts
+type JobStore interface {
+	Load() ([]*DownloadJob, error)
+	Save(job *DownloadJob) error
+	Delete(videoID string) error
+	Close() error
 }
 
 var (
 	DownloadJobs     = make(map[string]*DownloadJob)
 	DownloadJobsLock sync.Mutex
+	Store            JobStore
 )
 
+// SetStatus records a status transition and persists the job.
+// The caller must hold DownloadJobsLock.
+func (job *DownloadJob) SetStatus(status string) {
+	if job.Status == status {
+		return
+	}
+	now := time.Now().UTC()
+	job.Status = status
+	job.StatusHistory = append(job.StatusHistory, StatusChange{Status: status, At: now})
+	job.UpdatedAt = now
+	eventType := JobState
+	if status == "Finished" || status == "Error" || status == "Interrupted" {
+		job.EndedAt = now
+		eventType = JobFinished
+	}
+	saveJob(job)
+	publish(eventType, job)
+}
+
+// SaveDownloadJob persists a change of the job other than its status.
+// The caller must hold DownloadJobsLock.
+func SaveDownloadJob(job *DownloadJob) {
+	saveJob(job)
+	publish(JobUpdated, job)
+}
+
+// saveJob writes the job to the store, if one is configured
+func saveJob(job *DownloadJob) {
+	if Store == nil {
+		return
+	}
+	if err := Store.Save(job); err != nil {
+		golog.Warn("[system] Failed to save job ", job.VideoID, ": ", err)
+	}
+}
+
+// LoadDownloadJobs restores the jobs kept in the store. Jobs that were still
+// running when the process stopped are marked as Interrupted.
+func LoadDownloadJobs(store JobStore) error {
+	jobs, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	DownloadJobsLock.Lock()
+	defer DownloadJobsLock.Unlock()
+
+	Store = store
+	for _, job := range jobs {
+		if job.Status != "Finished" && job.Status != "Error" && job.Status != "Interrupted" {
+			job.SetStatus("Interrupted")
+		}
+		DownloadJobs[job.VideoID] = job
+	}
+	golog.Info("[system] Loaded ", len(jobs), " jobs from store")
+	return nil
+}
+
+// RemoveDownloadJob removes the job from memory and from the store.
+// The caller must hold DownloadJobsLock.
+func RemoveDownloadJob(videoID string) {
+	if job, exists := DownloadJobs[videoID]; exists {
+		publish(JobDeleted, job)
+	}
+	delete(DownloadJobs, videoID)
+	if Store == nil {
+		return
+	}
+	if err := Store.Delete(videoID); err != nil {
+		golog.Warn("[system] Failed to delete job ", videoID, ": ", err)
+	}
+}
+
+// CloseStore saves the final state of every job and closes the store. Jobs
+// whose process is still running are recorded as Interrupted.
+func CloseStore() {
+	DownloadJobsLock.Lock()
+	defer DownloadJobsLock.Unlock()
+
+	if Store == nil {
+		return
+	}
+	for _, job := range DownloadJobs {
+		if job.Process != nil {
+			job.SetStatus("Interrupted")
+		}
+		saveJob(job)
+	}
+	if err := Store.Close(); err != nil {
+		golog.Warn("[system] Failed to close store: ", err)
+	}
+	Store = nil
+}
+
 func IsVideoIDInDownloadJobs(videoID string) bool {
 	DownloadJobsLock.Lock()
 	defer DownloadJobsLock.Unlock()
@@ -56,27 +196,27 @@ func IsChannelIDInDownloadJobs(channelID string) bool {
 	return false
 }
 
-func IsChannelIDInDownloadJobsAndFinished(channelID string) bool {
-	DownloadJobsLock.Lock()
-	defer DownloadJobsLock.Unlock()
-
-	for _, job := range DownloadJobs {
-		if job.ChannelLive.ChannelID == channelID && job.Status != "Finished" {
-			return true
-		}
-	}
-	return false
-}
-
 func AddDownloadJob(videoID string, channelLive ChannelLive, status string, output string, outPath string) {
 	DownloadJobsLock.Lock()
 	defer DownloadJobsLock.Unlock()
 
-	DownloadJobs[videoID] = &DownloadJob{
+	now := time.Now().UTC()
+	job := &DownloadJob{
 		VideoID:     videoID,
 		ChannelLive: channelLive,
-		Status:      status,
 		Output:      output,
 		OutPath:     outPath,
+		CreatedAt:   now,
+	}
+	// Keep the history of a job that is being recorded again
+	if previous, exists := DownloadJobs[videoID]; exists {
+		job.StatusHistory = previous.StatusHistory
+		job.CreatedAt = previous.CreatedAt
+		job.Attempts = previous.Attempts
+		job.LastError = previous.LastError
+		job.NotificationMessages = previous.NotificationMessages
 	}
+	DownloadJobs[videoID] = job
+	publish(JobCreated, job)
+	job.SetStatus(status)
 }
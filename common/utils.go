@@ -6,61 +6,24 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"strings"
-	"sync"
 
 	"github.com/kataras/golog"
 )
 
+// CheckVideoRegex reports whether the title matches one of the filters. A
+// filter that doesn't compile is logged and skipped.
 func CheckVideoRegex(videoTitle string, filters []string) bool {
 	for _, filter := range filters {
-		if regexp.MustCompile(filter).MatchString(videoTitle) {
-			return true
-		}
-	}
-	return false
-}
-
-func ReadStdout(stdout io.Reader, outputBuffer []byte, parseOutput func(string, string), videoID string, wg *sync.WaitGroup, module string) {
-	defer wg.Done()
-	for {
-		n, err := stdout.Read(outputBuffer)
-		if err != nil {
-			if err != io.EOF {
-				golog.Debug(fmt.Sprintf("[%s] Error reading stdout:", module), err)
-			}
-			return
-		}
-
-		output := string(outputBuffer[:n])
-		lines := strings.Split(output, "\n")
-
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			parseOutput(line, videoID)
-		}
-	}
-}
-
-func ReadStderr(stderr io.Reader, outputBuffer []byte, parseOutput func(string, string), videoID string, wg *sync.WaitGroup, module string) {
-	defer wg.Done()
-	for {
-		n, err := stderr.Read(outputBuffer)
+		pattern, err := regexp.Compile(filter)
 		if err != nil {
-			if err != io.EOF {
-				golog.Debug(fmt.Sprintf("[%s] Error reading stderr:", module), err)
-			}
-			return
+			golog.Warn("[system] Skipping invalid filter ", filter, ": ", err)
+			continue
 		}
-
-		output := string(outputBuffer[:n])
-		lines := strings.Split(output, "\n")
-
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			parseOutput(line, videoID)
+		if pattern.MatchString(videoTitle) {
+			return true
 		}
 	}
+	return false
 }
 
 func MoveFile(sourcePath, destPath string) error {
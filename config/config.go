@@ -2,11 +2,13 @@
 package config
 
 import (
+	"errors"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kataras/golog"
-	"github.com/spf13/viper"
 )
 
 // Structs to hold application configuration
@@ -38,6 +40,19 @@ type ArchiveConfig struct {
 	Twitch                bool   `mapstructure:"twitch"`
 	YouTube               bool   `mapstructure:"youtube"`
 	TwitchUsingStreamlink bool   `mapstructure:"twitch_using_streamlink"`
+	Database              string `mapstructure:"database"`
+	ShutdownTimeout       int    `mapstructure:"shutdown_timeout"` // seconds to wait for running downloads
+	Concurrency           int    `mapstructure:"concurrency"`      // channels checked at the same time
+	Jitter                int    `mapstructure:"jitter"`           // max seconds added to every check interval
+	PrearmUpcoming        bool   `mapstructure:"prearm_upcoming"`  // start recording scheduled YouTube streams ahead of time
+	PrearmMinutes         int    `mapstructure:"prearm_minutes"`   // how long before the scheduled start
+}
+
+type RetryConfig struct {
+	MaxAttempts int `mapstructure:"max_attempts"`
+	Backoff     int `mapstructure:"backoff"`      // seconds before the first retry, doubled on every attempt
+	MaxBackoff  int `mapstructure:"max_backoff"`  // seconds
+	ResetWindow int `mapstructure:"reset_window"` // minutes a recording must run to reset the attempts
 }
 
 type DiscordConfig struct {
@@ -45,6 +60,44 @@ type DiscordConfig struct {
 	Webhook string `mapstructure:"webhook"`
 }
 
+// WebhookTemplate overrides the request of a webhook notifier for one event type
+type WebhookTemplate struct {
+	URL     string            `mapstructure:"url"`
+	Method  string            `mapstructure:"method"`
+	Headers map[string]string `mapstructure:"headers"`
+	Body    string            `mapstructure:"body"`
+}
+
+// NotifierConfig is a [[notifier]] entry
+type NotifierConfig struct {
+	Type   string   `mapstructure:"type"` // discord, webhook, telegram or email
+	Name   string   `mapstructure:"name"`
+	URL    string   `mapstructure:"url"`
+	Events []string `mapstructure:"events"` // Recording, Waiting, Progress, Done, Error; empty means all but Progress
+
+	// webhook, all of them are text/template strings
+	Method    string                     `mapstructure:"method"`
+	Headers   map[string]string          `mapstructure:"headers"`
+	Body      string                     `mapstructure:"body"` // defaults to the event as JSON
+	Templates map[string]WebhookTemplate `mapstructure:"templates"`
+
+	// telegram
+	BotToken string `mapstructure:"bot_token"`
+	ChatID   string `mapstructure:"chat_id"`
+	APIURL   string `mapstructure:"api_url"` // defaults to https://api.telegram.org
+
+	// email, mails only errors unless events is set
+	SMTPHost   string   `mapstructure:"smtp_host"`
+	SMTPPort   int      `mapstructure:"smtp_port"` // defaults to 587
+	Username   string   `mapstructure:"username"`
+	Password   string   `mapstructure:"password"`
+	StartTLS   bool     `mapstructure:"starttls"`
+	From       string   `mapstructure:"from"`
+	To         []string `mapstructure:"to"`
+	Digest     bool     `mapstructure:"digest"`      // mail the recordings of the last 24 hours once a day
+	DigestTime string   `mapstructure:"digest_time"` // local time as HH:MM, defaults to 08:00
+}
+
 type YouTubeChannel struct {
 	ID                   string   `mapstructure:"id"`
 	Name                 string   `mapstructure:"name"`
@@ -52,12 +105,54 @@ type YouTubeChannel struct {
 	OutPath              string   `mapstructure:"out_path"`
 	AlwaysDownloadMember bool     `mapstructure:"always_download_member" default:"false"`
 	UseMemberCookies     bool     `mapstructure:"use_member_cookies" default:"false"`
+	Downloader           string   `mapstructure:"downloader"`
+	Interval             int      `mapstructure:"interval"`  // minutes, overrides archive.checker
+	Detection            string   `mapstructure:"detection"` // "streams" (default) or "rss"
 }
 
 type TwitchChannel struct {
-	Name    string   `mapstructure:"name"`
-	Filters []string `mapstructure:"filters"`
-	OutPath string   `mapstructure:"out_path"`
+	Name       string   `mapstructure:"name"`
+	Filters    []string `mapstructure:"filters"`
+	OutPath    string   `mapstructure:"out_path"`
+	Downloader string   `mapstructure:"downloader"`
+	Interval   int      `mapstructure:"interval"` // minutes, overrides archive.checker
+	// DownloadVOD also downloads the VOD of a recorded stream after it ended
+	DownloadVOD bool `mapstructure:"download_vod"`
+}
+
+type TwitchConfig struct {
+	ClientID         string `mapstructure:"client_id"`
+	ClientSecret     string `mapstructure:"client_secret"`
+	EventSub         bool   `mapstructure:"eventsub"`
+	EventSubCallback string `mapstructure:"eventsub_callback"` // public url of /api/eventsub/twitch
+	EventSubSecret   string `mapstructure:"eventsub_secret"`
+}
+
+type WebSubConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	CallbackURL  string `mapstructure:"callback_url"` // public url of /api/websub/youtube
+	Secret       string `mapstructure:"secret"`
+	HubURL       string `mapstructure:"hub_url"`
+	LeaseSeconds int    `mapstructure:"lease_seconds"`
+}
+
+type AuthUser struct {
+	Username     string `mapstructure:"username"`
+	PasswordHash string `mapstructure:"password_hash"` // bcrypt, see -hash-password
+	Role         string `mapstructure:"role"`          // "admin" or "viewer" (default)
+}
+
+type AuthToken struct {
+	Name  string `mapstructure:"name"`
+	Token string `mapstructure:"token"`
+	Role  string `mapstructure:"role"` // "admin" or "viewer" (default)
+}
+
+// AuthConfig protects the web UI and api, it is disabled without users and tokens
+type AuthConfig struct {
+	Users        []AuthUser  `mapstructure:"user"`
+	Tokens       []AuthToken `mapstructure:"token"`
+	SessionHours int         `mapstructure:"session_hours"`
 }
 
 type WebserverConfig struct {
@@ -71,32 +166,71 @@ type Config struct {
 	YTArchive      YTArchive        `mapstructure:"ytarchive"`
 	Streamlink     StreamlinkConfig `mapstructure:"streamlink"`
 	Archive        ArchiveConfig    `mapstructure:"archive"`
+	Retry          RetryConfig      `mapstructure:"retry"`
 	Discord        DiscordConfig    `mapstructure:"discord"`
+	Notifier       []NotifierConfig `mapstructure:"notifier"`
 	YouTubeChannel []YouTubeChannel `mapstructure:"youtube_channel"` // Keep as slice
 	TwitchChannel  []TwitchChannel  `mapstructure:"twitch_channel"`  // Keep as slice
 	Webserver      WebserverConfig  `mapstructure:"webserver"`
+	WebSub         WebSubConfig     `mapstructure:"websub"`
+	Twitch         TwitchConfig     `mapstructure:"twitch"`
+	Auth           AuthConfig       `mapstructure:"auth"`
 }
 
-var AppConfig Config
-var lastModTime time.Time
+// current is the config in use. It is never modified, a reload swaps in a
+// new one, so it can be read from any goroutine.
+var current atomic.Pointer[Config]
 
-// LoadConfig reads the config file and populates AppConfig
-func LoadConfig() {
-	viper.SetConfigName("config") // name of config file (without extension)
-	viper.AddConfigPath(".")      // path to look for the config file
-	viper.SetConfigType("toml")   // file type
+var (
+	// reloadLock serializes reloads from the api and the file poller
+	reloadLock  sync.Mutex
+	lastModTime time.Time
+)
 
-	if err := viper.ReadInConfig(); err != nil {
-		golog.Fatal("Error reading config file, ", err)
+// File is the config file, relative to the working directory
+const File = "config.toml"
+
+// Get returns the config in use. It is shared, so it must not be modified.
+// Take it once when several fields have to agree with each other.
+func Get() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
 	}
+	return &Config{}
+}
 
-	// Unmarshal the config into the AppConfig struct
-	if err := viper.Unmarshal(&AppConfig); err != nil {
-		golog.Fatal("Unable to decode into struct, ", err)
+// Set makes cfg the config in use without reading the file
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// LoadConfig reads the config file and makes it the config in use. Problems
+// that don't stop the config from loading are only logged at startup.
+func LoadConfig() {
+	data, err := os.ReadFile(File)
+	if err != nil {
+		golog.Fatal("Error reading config file, ", err)
+	}
+	cfg, err := decode(data, true)
+	if err != nil {
+		// Keep starting with configs that only have unknown keys or loosely
+		// typed values, they are rejected when saved or reloaded
+		var errs ValidationError
+		errors.As(err, &errs)
+		for _, fieldErr := range errs {
+			golog.Warn("[config] ", fieldErr.Field, ": ", fieldErr.Message)
+		}
+		if cfg, err = decode(data, false); err != nil {
+			golog.Fatal("Unable to decode config file:\n", err)
+		}
+	}
+	for _, fieldErr := range Validate(cfg) {
+		golog.Warn("[config] ", fieldErr.Field, ": ", fieldErr.Message)
 	}
+	current.Store(cfg)
 
 	// Get initial mod time
-	if stat, err := os.Stat("config.toml"); err == nil {
+	if stat, err := os.Stat(File); err == nil {
 		lastModTime = stat.ModTime()
 	}
 
@@ -104,23 +238,47 @@ func LoadConfig() {
 	go pollConfigChanges()
 }
 
-// pollConfigChanges polls the config file for changes every 5 seconds
+// Reload reads and validates the config file and applies it when it is
+// valid, returning what changed. An invalid config is not applied.
+func Reload() (Diff, error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	if stat, err := os.Stat(File); err == nil {
+		lastModTime = stat.ModTime()
+	}
+	data, err := os.ReadFile(File)
+	if err != nil {
+		return Diff{}, err
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return Diff{}, err
+	}
+
+	diff := Compare(Get(), cfg)
+	current.Store(cfg)
+	for _, line := range diff.Lines() {
+		golog.Info("[config] ", line)
+	}
+	return diff, nil
+}
+
+// pollConfigChanges reloads the config file when it changed, checking every
+// 5 seconds. An invalid config is not applied, the running one is kept.
 func pollConfigChanges() {
 	for {
 		time.Sleep(5 * time.Second)
-		if stat, err := os.Stat("config.toml"); err == nil {
-			if stat.ModTime().After(lastModTime) {
-				golog.Info("Config file changed")
-				if err := viper.ReadInConfig(); err != nil {
-					golog.Error("Error reading config file:", err)
-					continue
-				}
-				if err := viper.Unmarshal(&AppConfig); err != nil {
-					golog.Error("Error reloading config:", err)
-				} else {
-					lastModTime = stat.ModTime()
-				}
-			}
+		stat, err := os.Stat(File)
+		reloadLock.Lock()
+		changed := err == nil && stat.ModTime().After(lastModTime)
+		reloadLock.Unlock()
+		if !changed {
+			continue
+		}
+		golog.Info("Config file changed")
+		if _, err := Reload(); err != nil {
+			golog.Error("Not applying the changed config:\n", err)
 		}
 	}
 }
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// restartFields only take effect after a restart
+var restartFields = map[string]bool{
+	"webserver.host":   true,
+	"webserver.port":   true,
+	"archive.database": true,
+	"websub.enabled":   true,
+	"twitch.eventsub":  true,
+}
+
+// Diff is what changed between two configs. Channels are named by their
+// section and id, other changes by the path of the field.
+type Diff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	// Restart lists the changed fields that only apply after a restart
+	Restart []string `json:"restart,omitempty"`
+}
+
+// Compare returns what changed from old to new
+func Compare(old *Config, new *Config) Diff {
+	var diff Diff
+
+	oldYouTube := make(map[string]any)
+	for _, channel := range old.YouTubeChannel {
+		oldYouTube[channel.ID] = channel
+	}
+	newYouTube := make(map[string]any)
+	for _, channel := range new.YouTubeChannel {
+		newYouTube[channel.ID] = channel
+	}
+	diff.compareChannels("youtube_channel", oldYouTube, newYouTube)
+
+	oldTwitch := make(map[string]any)
+	for _, channel := range old.TwitchChannel {
+		oldTwitch[strings.ToLower(channel.Name)] = channel
+	}
+	newTwitch := make(map[string]any)
+	for _, channel := range new.TwitchChannel {
+		newTwitch[strings.ToLower(channel.Name)] = channel
+	}
+	diff.compareChannels("twitch_channel", oldTwitch, newTwitch)
+
+	oldValue := reflect.ValueOf(old).Elem()
+	newValue := reflect.ValueOf(new).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "youtube_channel" || name == "twitch_channel" {
+			continue
+		}
+		if field.Type.Kind() != reflect.Struct {
+			diff.compareField(name, oldValue.Field(i), newValue.Field(i))
+			continue
+		}
+		for j := 0; j < field.Type.NumField(); j++ {
+			subName := name + "." + field.Type.Field(j).Tag.Get("mapstructure")
+			diff.compareField(subName, oldValue.Field(i).Field(j), newValue.Field(i).Field(j))
+		}
+	}
+	return diff
+}
+
+func (d *Diff) compareChannels(section string, old map[string]any, new map[string]any) {
+	for _, id := range sortedKeys(new) {
+		channel := new[id]
+		previous, exists := old[id]
+		if !exists {
+			d.Added = append(d.Added, section+" "+id)
+		} else if !reflect.DeepEqual(previous, channel) {
+			d.Changed = append(d.Changed, section+" "+id)
+		}
+	}
+	for _, id := range sortedKeys(old) {
+		if _, exists := new[id]; !exists {
+			d.Removed = append(d.Removed, section+" "+id)
+		}
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *Diff) compareField(name string, old reflect.Value, new reflect.Value) {
+	if reflect.DeepEqual(old.Interface(), new.Interface()) {
+		return
+	}
+	d.Changed = append(d.Changed, name)
+	if restartFields[name] {
+		d.Restart = append(d.Restart, name)
+	}
+}
+
+// Lines describes the diff for the log, without any values as they may be secrets
+func (d Diff) Lines() []string {
+	var lines []string
+	for _, added := range d.Added {
+		lines = append(lines, "added "+added)
+	}
+	for _, removed := range d.Removed {
+		lines = append(lines, "removed "+removed)
+	}
+	for _, changed := range d.Changed {
+		if restartFields[changed] {
+			lines = append(lines, "changed "+changed+", restart to apply it")
+		} else {
+			lines = append(lines, "changed "+changed)
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "nothing changed")
+	}
+	return lines
+}
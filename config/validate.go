@@ -0,0 +1,413 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// FieldError is a problem with one field of the config. Field is the path of
+// the field like "youtube_channel[0].filters[1]", empty for the whole file.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists everything wrong with a config
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	lines := make([]string, len(e))
+	for i, fieldErr := range e {
+		if fieldErr.Field == "" {
+			lines[i] = fieldErr.Message
+		} else {
+			lines[i] = fieldErr.Field + ": " + fieldErr.Message
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// decodeErrorPattern finds the field in errors like "cannot parse 'archive.checker' as int"
+var decodeErrorPattern = regexp.MustCompile(`'([^']+)'`)
+
+// unusedKeysPattern matches the error of keys that are not in Config
+var unusedKeysPattern = regexp.MustCompile(`^'([^']*)' has invalid keys: (.*)$`)
+
+// numberToString lets numbers be used for string fields like webserver.port
+// and chat_id, the only loose typing the config allows
+func numberToString(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if to.Kind() != reflect.String {
+		return data, nil
+	}
+	switch from.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprint(data), nil
+	}
+	return data, nil
+}
+
+// Parse decodes and validates a TOML config without applying it
+func Parse(data []byte) (*Config, error) {
+	cfg, err := decode(data, true)
+	if err != nil {
+		return nil, err
+	}
+	if errs := Validate(cfg); len(errs) > 0 {
+		return nil, errs
+	}
+	return cfg, nil
+}
+
+// decode only checks the syntax and the types of a TOML config. Unless
+// strict, unknown keys are ignored and values are converted where possible.
+func decode(data []byte, strict bool) (*Config, error) {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, ValidationError{{Message: err.Error()}}
+	}
+
+	var cfg Config
+	option := viper.DecoderConfigOption(func(c *mapstructure.DecoderConfig) {
+		if strict {
+			// Unknown keys are typos, and "5" is not a number
+			c.ErrorUnused = true
+			c.WeaklyTypedInput = false
+			c.DecodeHook = mapstructure.ComposeDecodeHookFunc(c.DecodeHook, numberToString)
+		}
+	})
+	if err := v.Unmarshal(&cfg, option); err != nil {
+		var decodeErr *mapstructure.Error
+		if !errors.As(err, &decodeErr) {
+			return nil, ValidationError{{Message: err.Error()}}
+		}
+		var errs ValidationError
+		for _, message := range decodeErr.Errors {
+			if match := unusedKeysPattern.FindStringSubmatch(message); match != nil {
+				for _, key := range strings.Split(match[2], ", ") {
+					field := key
+					if match[1] != "" {
+						field = match[1] + "." + key
+					}
+					errs = append(errs, FieldError{Field: field, Message: "unknown key"})
+				}
+			} else if match := decodeErrorPattern.FindStringSubmatch(message); match != nil {
+				errs = append(errs, FieldError{Field: match[1], Message: message})
+			} else {
+				errs = append(errs, FieldError{Message: message})
+			}
+		}
+		return nil, errs
+	}
+	return &cfg, nil
+}
+
+// Validate checks what decoding can't: required fields, known values,
+// filters that compile, executables that exist and out paths that are writable
+func Validate(cfg *Config) ValidationError {
+	var errs ValidationError
+	add := func(field string, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	oneOf := func(field string, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		add(field, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+	notNegative := func(field string, value int) {
+		if value < 0 {
+			add(field, "must not be negative")
+		}
+	}
+
+	executables := []struct {
+		field string
+		path  string
+		dir   string
+	}{
+		{"yt-dlp", cfg.YT_DLP.ExecutablePath, cfg.YT_DLP.WorkingDirectory},
+		{"ytarchive", cfg.YTArchive.ExecutablePath, cfg.YTArchive.WorkingDirectory},
+		{"streamlink", cfg.Streamlink.ExecutablePath, cfg.Streamlink.WorkingDirectory},
+	}
+	for _, e := range executables {
+		if e.path != "" {
+			if _, err := exec.LookPath(e.path); err != nil {
+				add(e.field+".executable_path", "%q was not found", e.path)
+			}
+		}
+		if e.dir != "" {
+			if err := writable(e.dir); err != nil {
+				add(e.field+".working_directory", "%v", err)
+			}
+		}
+	}
+	if cfg.YTArchive.OutPath != "" {
+		if err := writable(cfg.YTArchive.OutPath); err != nil {
+			add("ytarchive.out_path", "%v", err)
+		}
+	}
+
+	notNegative("archive.checker", cfg.Archive.Checker)
+	notNegative("archive.shutdown_timeout", cfg.Archive.ShutdownTimeout)
+	notNegative("archive.concurrency", cfg.Archive.Concurrency)
+	notNegative("archive.jitter", cfg.Archive.Jitter)
+	notNegative("archive.prearm_minutes", cfg.Archive.PrearmMinutes)
+	notNegative("retry.max_attempts", cfg.Retry.MaxAttempts)
+	notNegative("retry.backoff", cfg.Retry.Backoff)
+	notNegative("retry.max_backoff", cfg.Retry.MaxBackoff)
+	notNegative("retry.reset_window", cfg.Retry.ResetWindow)
+
+	if cfg.Webserver.Port == "" {
+		add("webserver.port", "is required")
+	}
+	if cfg.WebSub.Enabled && cfg.WebSub.CallbackURL == "" {
+		add("websub.callback_url", "is required when websub is enabled")
+	}
+	if cfg.WebSub.Enabled && cfg.WebSub.Secret == "" {
+		add("websub.secret", "is required when websub is enabled, unsigned notifications are ignored")
+	}
+	if cfg.Twitch.EventSub {
+		if cfg.Twitch.ClientID == "" || cfg.Twitch.ClientSecret == "" {
+			add("twitch", "client_id and client_secret are required when eventsub is enabled")
+		}
+		if !strings.HasPrefix(cfg.Twitch.EventSubCallback, "https://") {
+			add("twitch.eventsub_callback", "must be an https url")
+		}
+		if len(cfg.Twitch.EventSubSecret) < 10 || len(cfg.Twitch.EventSubSecret) > 100 {
+			add("twitch.eventsub_secret", "must be 10 to 100 characters")
+		}
+	}
+	if cfg.Discord.Notify && cfg.Discord.Webhook == "" {
+		add("discord.webhook", "is required when notify is enabled")
+	}
+
+	for i, n := range cfg.Notifier {
+		field := fmt.Sprintf("notifier[%d]", i)
+		for j, event := range n.Events {
+			oneOf(fmt.Sprintf("%s.events[%d]", field, j), event, "Recording", "Waiting", "Progress", "Done", "Error")
+		}
+		switch n.Type {
+		case "discord", "webhook":
+			if n.URL == "" {
+				add(field+".url", "is required for a %s notifier", n.Type)
+			}
+			if n.Type == "webhook" {
+				validateWebhookTemplate(field, WebhookTemplate{URL: n.URL, Method: n.Method, Headers: n.Headers, Body: n.Body}, add)
+				for event, override := range n.Templates {
+					validateWebhookTemplate(field+".templates."+event, override, add)
+				}
+			}
+		case "telegram":
+			if n.BotToken == "" {
+				add(field+".bot_token", "is required for a telegram notifier")
+			}
+			if n.ChatID == "" {
+				add(field+".chat_id", "is required for a telegram notifier")
+			}
+		case "email":
+			if n.SMTPHost == "" {
+				add(field+".smtp_host", "is required for an email notifier")
+			}
+			if n.From == "" {
+				add(field+".from", "is required for an email notifier")
+			}
+			if len(n.To) == 0 {
+				add(field+".to", "is required for an email notifier")
+			}
+			if n.DigestTime != "" {
+				if _, err := time.Parse("15:04", n.DigestTime); err != nil {
+					add(field+".digest_time", "must be HH:MM, got %q", n.DigestTime)
+				}
+			}
+		default:
+			oneOf(field+".type", n.Type, "discord", "webhook", "telegram", "email")
+		}
+	}
+
+	for i, channel := range cfg.YouTubeChannel {
+		field := fmt.Sprintf("youtube_channel[%d]", i)
+		if channel.ID == "" {
+			add(field+".id", "is required")
+		}
+		oneOf(field+".downloader", channel.Downloader, "", "ytarchive", "yt-dlp")
+		oneOf(field+".detection", channel.Detection, "", "streams", "rss")
+		notNegative(field+".interval", channel.Interval)
+		validateFilters(field, channel.Filters, add)
+		validateOutPath(field, channel.OutPath, add)
+	}
+	for i, channel := range cfg.TwitchChannel {
+		field := fmt.Sprintf("twitch_channel[%d]", i)
+		if channel.Name == "" {
+			add(field+".name", "is required")
+		}
+		oneOf(field+".downloader", channel.Downloader, "", "streamlink", "yt-dlp")
+		notNegative(field+".interval", channel.Interval)
+		validateFilters(field, channel.Filters, add)
+		validateOutPath(field, channel.OutPath, add)
+	}
+
+	for i, user := range cfg.Auth.Users {
+		field := fmt.Sprintf("auth.user[%d]", i)
+		if user.Username == "" {
+			add(field+".username", "is required")
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			add(field+".password_hash", "must be a bcrypt hash, see -hash-password")
+		}
+		oneOf(field+".role", user.Role, "", "admin", "viewer")
+	}
+	for i, token := range cfg.Auth.Tokens {
+		field := fmt.Sprintf("auth.token[%d]", i)
+		if token.Token == "" {
+			add(field+".token", "is required")
+		}
+		oneOf(field+".role", token.Role, "", "admin", "viewer")
+	}
+	notNegative("auth.session_hours", cfg.Auth.SessionHours)
+
+	return errs
+}
+
+// WebhookFuncs are the functions available to webhook notifier templates
+var WebhookFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, for use inside JSON bodies
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func validateWebhookTemplate(field string, tmpl WebhookTemplate, add func(string, string, ...any)) {
+	parse := func(name string, text string) {
+		if _, err := template.New(name).Funcs(WebhookFuncs).Parse(text); err != nil {
+			add(field+"."+name, "invalid template: %v", err)
+		}
+	}
+	parse("url", tmpl.URL)
+	parse("method", tmpl.Method)
+	parse("body", tmpl.Body)
+	for key, value := range tmpl.Headers {
+		parse("headers."+key, value)
+	}
+}
+
+func validateFilters(field string, filters []string, add func(string, string, ...any)) {
+	for i, filter := range filters {
+		if _, err := regexp.Compile(filter); err != nil {
+			add(fmt.Sprintf("%s.filters[%d]", field, i), "%v", err)
+		}
+	}
+}
+
+func validateOutPath(field string, outPath string, add func(string, string, ...any)) {
+	if outPath == "" {
+		add(field+".out_path", "is required")
+	} else if err := writable(outPath); err != nil {
+		add(field+".out_path", "%v", err)
+	}
+}
+
+// writable checks that files can be created in dir. A missing dir is created
+// when needed, so then its closest existing parent has to be writable.
+func writable(dir string) error {
+	path := filepath.Clean(dir)
+	for {
+		info, err := os.Stat(path)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%q is not a directory", path)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return fmt.Errorf("%q does not exist", dir)
+		}
+		path = parent
+	}
+
+	f, err := os.CreateTemp(path, ".streamwatcher-write-test-*")
+	if err != nil {
+		return fmt.Errorf("%q is not writable", path)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// rename replaces the config with the new file, swapped in tests
+var rename = os.Rename
+
+// Save writes a config atomically, keeping the previous one as path.bak
+func Save(path string, data []byte) error {
+	if previous, err := os.ReadFile(path); err == nil {
+		if err := os.WriteFile(path+".bak", previous, 0644); err != nil {
+			return fmt.Errorf("backing up %s: %w", path, err)
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	err = rename(f.Name(), path)
+	// A config bind mounted as a single file, like in docker-compose.yml,
+	// can't be replaced, only written in place
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+		return writeInPlace(path, data)
+	}
+	return err
+}
+
+func writeInPlace(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fields returns the sorted fields of a validation error
+func fields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("error %T is not a ValidationError: %v", err, err)
+	}
+	var result []string
+	for _, fieldErr := range errs {
+		result = append(result, fieldErr.Field)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want []string
+	}{
+		{name: "valid", toml: "[archive]\nchecker = 5\n"},
+		{name: "number for a string field", toml: "[webserver]\nport = 3000\n"},
+		{name: "unknown keys", toml: "foo = 1\n[archive]\nchekcer = 1\n", want: []string{"archive.chekcer", "foo"}},
+		{name: "unknown key in a channel", toml: "[[youtube_channel]]\nid = \"x\"\nfilter = [\"a\"]\n", want: []string{"youtube_channel[0].filter"}},
+		{name: "unknown key in a template", toml: "[[notifier]]\ntype = \"webhook\"\n[notifier.templates.Done]\nmethd = \"GET\"\n", want: []string{"notifier[0].templates[done].methd"}},
+		{name: "string for an int field", toml: "[archive]\nchecker = \"5\"\n", want: []string{"archive.checker"}},
+		{name: "syntax error", toml: "[archive\n", want: []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decode([]byte(tt.toml), true)
+			if got := fields(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields = %q, want %q (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestDecodeLoose(t *testing.T) {
+	cfg, err := decode([]byte("foo = 1\n[archive]\nchecker = \"5\"\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Archive.Checker != 5 {
+		t.Errorf("checker = %d, want 5", cfg.Archive.Checker)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string
+	}{
+		{name: "valid", modify: func(cfg *Config) {}},
+		{name: "missing out path below a writable dir", modify: func(cfg *Config) {
+			cfg.YouTubeChannel[0].OutPath = filepath.Join(dir, "new", "nested")
+		}},
+		{name: "out path is a file", modify: func(cfg *Config) {
+			cfg.TwitchChannel[0].OutPath = file
+		}, want: []string{"twitch_channel[0].out_path"}},
+		{name: "out path below a file", modify: func(cfg *Config) {
+			cfg.YTArchive.OutPath = filepath.Join(file, "below")
+		}, want: []string{"ytarchive.out_path"}},
+		{name: "bad filter", modify: func(cfg *Config) {
+			cfg.YouTubeChannel[0].Filters = []string{"ok", "(unclosed"}
+		}, want: []string{"youtube_channel[0].filters[1]"}},
+		{name: "unknown values", modify: func(cfg *Config) {
+			cfg.YouTubeChannel[0].Downloader = "streamlink"
+			cfg.YouTubeChannel[0].Detection = "push"
+			cfg.TwitchChannel[0].Downloader = "ytarchive"
+		}, want: []string{"twitch_channel[0].downloader", "youtube_channel[0].detection", "youtube_channel[0].downloader"}},
+		{name: "negative numbers", modify: func(cfg *Config) {
+			cfg.Archive.Checker = -1
+			cfg.Retry.Backoff = -1
+			cfg.TwitchChannel[0].Interval = -1
+		}, want: []string{"archive.checker", "retry.backoff", "twitch_channel[0].interval"}},
+		{name: "missing required fields", modify: func(cfg *Config) {
+			cfg.Webserver.Port = ""
+			cfg.YouTubeChannel[0].ID = ""
+			cfg.TwitchChannel[0].Name = ""
+		}, want: []string{"twitch_channel[0].name", "webserver.port", "youtube_channel[0].id"}},
+		{name: "executable not found", modify: func(cfg *Config) {
+			cfg.Streamlink.ExecutablePath = filepath.Join(dir, "streamlink")
+		}, want: []string{"streamlink.executable_path"}},
+		{name: "websub without callback and secret", modify: func(cfg *Config) {
+			cfg.WebSub.Enabled = true
+		}, want: []string{"websub.callback_url", "websub.secret"}},
+		{name: "notifiers", modify: func(cfg *Config) {
+			cfg.Notifier = []NotifierConfig{
+				{Type: "pager"},
+				{Type: "webhook", URL: "http://example.com", Events: []string{"Done", "Finished"}, Body: "{{.Live"},
+				{Type: "email", SMTPHost: "smtp", From: "a@example.com", To: []string{"b@example.com"}, DigestTime: "8am"},
+			}
+		}, want: []string{"notifier[0].type", "notifier[1].body", "notifier[1].events[1]", "notifier[2].digest_time"}},
+		{name: "auth", modify: func(cfg *Config) {
+			cfg.Auth.Users = []AuthUser{{Username: "admin", PasswordHash: "plain", Role: "owner"}}
+			cfg.Auth.Tokens = []AuthToken{{Role: "admin"}}
+		}, want: []string{"auth.token[0].token", "auth.user[0].password_hash", "auth.user[0].role"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Webserver:      WebserverConfig{Port: "3000"},
+				YouTubeChannel: []YouTubeChannel{{ID: "UC1", OutPath: dir}},
+				TwitchChannel:  []TwitchChannel{{Name: "streamer", OutPath: dir}},
+			}
+			tt.modify(cfg)
+			var err error
+			if errs := Validate(cfg); len(errs) > 0 {
+				err = errs
+			}
+			if got := fields(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields = %q, want %q (%v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestWritableReadOnlyDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any directory")
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	if err := writable(dir); err == nil {
+		t.Error("read-only dir is writable")
+	}
+	if err := writable(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing dir below a read-only dir is writable")
+	}
+}
+
+func TestSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+
+	if err := Save(path, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("backup of a new config exists: %v", err)
+	}
+	if err := Save(path, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFile(t, path, "second")
+	assertFile(t, path+".bak", "first")
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, %v, want 0644", info.Mode().Perm(), err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 2 {
+		t.Errorf("files = %v, want only the config and its backup", entries)
+	}
+}
+
+func TestSaveFallsBackToWritingInPlace(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.EBUSY, syscall.EXDEV} {
+		t.Run(errno.Error(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte("a longer previous config"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			before, _ := os.Stat(path)
+			setRename(t, func(from, to string) error {
+				return &os.LinkError{Op: "rename", Old: from, New: to, Err: errno}
+			})
+
+			if err := Save(path, []byte("new")); err != nil {
+				t.Fatal(err)
+			}
+			assertFile(t, path, "new")
+			assertFile(t, path+".bak", "a longer previous config")
+			if after, _ := os.Stat(path); !os.SameFile(before, after) {
+				t.Error("config was replaced instead of written in place")
+			}
+			if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 2 {
+				t.Errorf("files = %v, want only the config and its backup", entries)
+			}
+		})
+	}
+}
+
+func TestSaveRenameError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setRename(t, func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EACCES}
+	})
+
+	if err := Save(path, []byte("new")); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("Save = %v, want the rename error", err)
+	}
+	assertFile(t, path, "previous")
+}
+
+func setRename(t *testing.T, fn func(string, string) error) {
+	t.Helper()
+	previous := rename
+	rename = fn
+	t.Cleanup(func() { rename = previous })
+}
+
+func assertFile(t *testing.T, path string, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("%s = %q, want %q", filepath.Base(path), data, want)
+	}
+}
@@ -3,8 +3,17 @@ package discord
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"streamwatcher/common"
 	"streamwatcher/config"
+	"streamwatcher/helpers/notifier"
+	"sync"
+	"time"
 
 	"github.com/kataras/golog"
 )
@@ -23,11 +32,18 @@ type Thumbnail struct {
 	URL string `json:"url"`
 }
 
+type Field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
 type Embed struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	Color       int       `json:"color"`
 	Author      Author    `json:"author"`
+	Fields      []Field   `json:"fields,omitempty"`
 	Footer      Footer    `json:"footer"`
 	Thumbnail   Thumbnail `json:"thumbnail"`
 }
@@ -39,67 +55,298 @@ type DiscordPayload struct {
 	Attachments []string    `json:"attachments"`
 }
 
-func SendNotificationWebhook(channelName string, title string, videoUrl string, thumbnailUrl string, status string) {
-	color := map[string]int{
-		"Recording": 65280,
-		"Done":      9934835,
-		"Error":     16711680,
-	}
+var colors = map[string]int{
+	notifier.EventRecording: 65280,
+	notifier.EventWaiting:   16763904,
+	notifier.EventProgress:  65280,
+	notifier.EventDone:      9934835,
+	notifier.EventError:     16711680,
+}
+
+var authorSuffix = map[string]string{
+	notifier.EventRecording: " is live!",
+	notifier.EventWaiting:   " is going live soon",
+	notifier.EventProgress:  " is live!",
+	notifier.EventDone:      " finished streaming",
+	notifier.EventError:     " could not be recorded",
+}
+
+var vodAuthorSuffix = map[string]string{
+	notifier.EventRecording: "'s VOD is being downloaded",
+	notifier.EventProgress:  "'s VOD is being downloaded",
+	notifier.EventDone:      "'s VOD was downloaded",
+	notifier.EventError:     "'s VOD could not be downloaded",
+}
+
+// message is the embed of a recording, edited as the recording progresses.
+// It is forgotten once Done or Error was sent, so a new recording of the same
+// video gets a new message.
+type message struct {
+	lock sync.Mutex
+	id   string
+}
 
-	if config.AppConfig.Discord.Notify {
-		headers := map[string]string{
-			"Content-Type": "application/json",
+var (
+	messages     = make(map[string]*message)
+	messagesLock sync.Mutex
+)
+
+// Notifier posts one embed per recording to a Discord webhook and keeps
+// editing it until the recording finished
+type Notifier struct {
+	name    string
+	webhook string
+}
+
+func init() {
+	notifier.Register("discord", func(cfg config.NotifierConfig) (notifier.Notifier, error) {
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("discord notifier needs a webhook url")
 		}
-		payload := DiscordPayload{
-			Content: nil,
-			Embeds: []Embed{
-				{
-					Title:       status,
-					Description: title,
-					Color:       color[status],
-					Author: Author{
-						Name:    channelName + " is live!",
-						URL:     videoUrl,
-						IconURL: nil,
-					},
-					Footer: Footer{
-						Text: "Shiodome v0.0.1",
-					},
-					Thumbnail: Thumbnail{
-						URL: thumbnailUrl,
-					},
-				},
-			},
-			Username:    "Shiodome",
-			Attachments: []string{},
+		name := cfg.Name
+		if name == "" {
+			name = "discord"
 		}
+		return &Notifier{name: name, webhook: cfg.URL}, nil
+	})
+}
 
-		jsonPayload, err := json.Marshal(payload)
-		if err != nil {
-			return
-		}
+func (n *Notifier) Name() string {
+	return n.name
+}
 
-		client := &http.Client{}
-		req, err := http.NewRequest("POST", config.AppConfig.Discord.Webhook, bytes.NewBuffer(jsonPayload))
-		if err != nil {
-			golog.Error("[discord] send notification error:", err)
-			return
+// TracksProgress makes the notifier receive Progress events
+func (n *Notifier) TracksProgress() bool {
+	return true
+}
+
+// messageFor returns the message of a recording, picking up the id stored on
+// the job after a restart
+func (n *Notifier) messageFor(videoID string) *message {
+	messagesLock.Lock()
+	defer messagesLock.Unlock()
+
+	key := n.name + "/" + videoID
+	msg, exists := messages[key]
+	if !exists {
+		msg = &message{}
+		common.DownloadJobsLock.Lock()
+		if job, exists := common.DownloadJobs[videoID]; exists {
+			msg.id = job.NotificationMessages[n.name]
 		}
-		for key, value := range headers {
-			req.Header.Set(key, value)
+		common.DownloadJobsLock.Unlock()
+		messages[key] = msg
+	}
+	return msg
+}
+
+// forget drops the message of a recording
+func (n *Notifier) forget(videoID string, msg *message) {
+	messagesLock.Lock()
+	defer messagesLock.Unlock()
+
+	key := n.name + "/" + videoID
+	if messages[key] == msg {
+		delete(messages, key)
+	}
+}
+
+// storeMessageID keeps the message id on the job, the job may only be
+// created after the Recording message was sent. An empty id removes it.
+func (n *Notifier) storeMessageID(videoID string, id string) {
+	common.DownloadJobsLock.Lock()
+	defer common.DownloadJobsLock.Unlock()
+
+	job, exists := common.DownloadJobs[videoID]
+	if !exists || job.NotificationMessages[n.name] == id {
+		return
+	}
+	if id == "" {
+		delete(job.NotificationMessages, n.name)
+	} else {
+		if job.NotificationMessages == nil {
+			job.NotificationMessages = make(map[string]string)
 		}
-		resp, err := client.Do(req)
+		job.NotificationMessages[n.name] = id
+	}
+	common.SaveDownloadJob(job)
+}
+
+func (n *Notifier) Notify(event notifier.Event) error {
+	msg := n.messageFor(event.Live.VideoID)
+	msg.lock.Lock()
+	defer msg.lock.Unlock()
+
+	final := event.Type == notifier.EventDone || event.Type == notifier.EventError
+	if event.Type == notifier.EventProgress && msg.id == "" {
+		n.forget(event.Live.VideoID, msg)
+		return nil
+	}
+
+	payload := DiscordPayload{
+		Content:     nil,
+		Embeds:      []Embed{embed(event)},
+		Username:    "Shiodome",
+		Attachments: []string{},
+	}
+
+	if msg.id == "" {
+		id, err := n.send(http.MethodPost, "", payload)
 		if err != nil {
-			golog.Error("[discord] error sending request:", err)
-			return
+			return err
 		}
-		defer resp.Body.Close()
+		msg.id = id
+	} else if _, err := n.send(http.MethodPatch, msg.id, payload); err != nil {
+		return err
+	}
+	if final {
+		n.forget(event.Live.VideoID, msg)
+		n.storeMessageID(event.Live.VideoID, "")
+	} else {
+		n.storeMessageID(event.Live.VideoID, msg.id)
+	}
+	golog.Debug("[discord] send notification successfully")
+	return nil
+}
+
+func embed(event notifier.Event) Embed {
+	suffix := authorSuffix[event.Type]
+	if event.Live.VOD {
+		suffix = vodAuthorSuffix[event.Type]
+	}
+	e := Embed{
+		Title:       event.Label(),
+		Description: event.Live.Title,
+		Color:       colors[event.Type],
+		Author: Author{
+			Name:    event.Live.ChannelName + suffix,
+			URL:     event.URL,
+			IconURL: nil,
+		},
+		Footer: Footer{
+			Text: "Shiodome v0.0.1",
+		},
+		Thumbnail: Thumbnail{
+			URL: event.Live.ThumbnailUrl,
+		},
+	}
 
-		if resp.StatusCode != 400 {
-			golog.Debug("[discord] send notification successfully")
-		} else {
-			golog.Error("[discord] send notification error")
+	job := event.Job
+	if job == nil {
+		return e
+	}
+	if event.Type == notifier.EventProgress {
+		recording := event
+		recording.Type = notifier.EventRecording
+		e.Title = recording.Label()
+	}
+	e.Fields = append(e.Fields, Field{Name: "Status", Value: job.Status, Inline: true})
+	end := time.Now()
+	if !job.EndedAt.IsZero() {
+		end = job.EndedAt
+	}
+	e.Fields = append(e.Fields, Field{Name: "Elapsed", Value: end.Sub(job.CreatedAt).Round(time.Second).String(), Inline: true})
+
+	switch event.Type {
+	case notifier.EventDone:
+		e.Fields = append(e.Fields, Field{Name: "File", Value: filepath.Base(job.FinalFile)})
+		if info, err := os.Stat(job.FinalFile); err == nil {
+			e.Fields = append(e.Fields, Field{Name: "Size", Value: notifier.FormatSize(info.Size()), Inline: true})
+		}
+	case notifier.EventError:
+		output := event.Error
+		if output == "" {
+			output = job.Output
+		}
+		e.Fields = append(e.Fields, Field{Name: "Last output", Value: "```\n" + notifier.Truncate(output, 1000) + "\n```"})
+	default:
+		if job.TotalSize != "" {
+			e.Fields = append(e.Fields, Field{Name: "Size", Value: job.TotalSize, Inline: true})
 		}
+		if job.VideoFragments != "" || job.AudioFragments != "" {
+			e.Fields = append(e.Fields, Field{Name: "Fragments", Value: "V: " + job.VideoFragments + " / A: " + job.AudioFragments, Inline: true})
+		}
+	}
+	return e
+}
+
+// send posts a new message and returns its id, or edits the message with the given id
+func (n *Notifier) send(method string, id string, payload DiscordPayload) (string, error) {
+	u, err := url.Parse(n.webhook)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	if id == "" {
+		query.Set("wait", "true")
+	} else {
+		u.Path += "/messages/" + id
+	}
+	u.RawQuery = query.Encode()
 
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
 	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	waitForBucket(n.webhook)
+	resp, err := notifier.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	updateBucket(n.webhook, resp)
+
+	if err := notifier.CheckResponse("discord", resp); err != nil {
+		return "", err
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("[discord] invalid webhook response: %w", err)
+	}
+	return created.ID, nil
+}
+
+// resets is when the rate limit bucket of a webhook allows requests again
+var (
+	resets     = make(map[string]time.Time)
+	resetsLock sync.Mutex
+)
+
+func waitForBucket(webhook string) {
+	resetsLock.Lock()
+	reset := resets[webhook]
+	resetsLock.Unlock()
+
+	if wait := time.Until(reset); wait > 0 {
+		golog.Debug("[discord] rate limited, waiting ", wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// updateBucket remembers the reset of an exhausted bucket from the
+// X-RateLimit headers, a 429 also blocks the webhook for its Retry-After
+func updateBucket(webhook string, resp *http.Response) {
+	resetAfter, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset-After"), 64)
+	exhausted := resp.Header.Get("X-RateLimit-Remaining") == "0"
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter, retryErr := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); retryErr == nil {
+			resetAfter, err = retryAfter, nil
+		}
+		exhausted = true
+	}
+	if err != nil || !exhausted {
+		return
+	}
+
+	resetsLock.Lock()
+	defer resetsLock.Unlock()
+	resets[webhook] = time.Now().Add(time.Duration(resetAfter * float64(time.Second)))
 }
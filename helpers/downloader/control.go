@@ -0,0 +1,114 @@
+package downloader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"streamwatcher/common"
+
+	"github.com/kataras/golog"
+)
+
+var (
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobNotRunning = errors.New("job is not running")
+)
+
+// Stop asks the downloader of a job to exit gracefully, so it still muxes what
+// it has recorded. The job ends up as Interrupted.
+func Stop(videoID string) error {
+	common.DownloadJobsLock.Lock()
+	defer common.DownloadJobsLock.Unlock()
+
+	job, exists := common.DownloadJobs[videoID]
+	if !exists {
+		return ErrJobNotFound
+	}
+	if job.Process == nil && job.Status == "Retrying" {
+		golog.Info("[downloader] Cancelling retry of job: ", videoID)
+		job.Stopped = true
+		job.SetStatus("Interrupted")
+		return nil
+	}
+	if job.Process == nil {
+		return ErrJobNotRunning
+	}
+
+	golog.Info("[downloader] Stopping job: ", videoID)
+	job.Stopped = true
+	return interrupt(job.Process)
+}
+
+// Delete kills the downloader of a job and removes the job with its temporary
+// fragments. A running or finalizing job is removed once its process exited
+// and it was finalized.
+func Delete(videoID string) error {
+	common.DownloadJobsLock.Lock()
+	defer common.DownloadJobsLock.Unlock()
+
+	job, exists := common.DownloadJobs[videoID]
+	if !exists {
+		return ErrJobNotFound
+	}
+
+	golog.Info("[downloader] Deleting job: ", videoID)
+	if job.Process != nil {
+		job.Deleted = true
+		return job.Process.Kill()
+	}
+	if job.Finalizing {
+		job.Deleted = true
+		return nil
+	}
+
+	common.RemoveDownloadJob(videoID)
+	if d := Get(job.Downloader); d != nil {
+		_, workingDirectory := d.Command()
+		removeTempFiles(workingDirectory, videoID)
+	}
+	return nil
+}
+
+// killAll kills every running downloader, marking its job as Interrupted
+func killAll() {
+	common.DownloadJobsLock.Lock()
+	defer common.DownloadJobsLock.Unlock()
+
+	for _, job := range common.DownloadJobs {
+		if job.Process == nil {
+			continue
+		}
+		golog.Warn("[downloader] Killing job: ", job.VideoID)
+		job.Stopped = true
+		if err := job.Process.Kill(); err != nil {
+			golog.Warn("[downloader] Failed to kill job ", job.VideoID, ": ", err)
+		}
+	}
+}
+
+func interrupt(process *os.Process) error {
+	// Windows has no SIGINT for child processes
+	if runtime.GOOS == "windows" {
+		return process.Kill()
+	}
+	return process.Signal(os.Interrupt)
+}
+
+// removeTempFiles removes the fragments a downloader left in its working directory
+func removeTempFiles(workingDirectory string, videoID string) {
+	if videoID == "" {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(workingDirectory, "*"+videoID+"*"))
+	if err != nil {
+		golog.Warn("[downloader] Failed to list temp files: ", err)
+		return
+	}
+	for _, match := range matches {
+		golog.Debug("[downloader] Removing temp file: ", match)
+		if err := os.RemoveAll(match); err != nil {
+			golog.Warn("[downloader] Failed to remove temp file: ", err)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"streamwatcher/common"
+	"streamwatcher/helpers/notifier"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// Downloader is a backend that records a stream with an external program.
+// The process handling is shared, a backend only builds the arguments and
+// parses the output of its program.
+type Downloader interface {
+	Name() string
+	// Command returns the executable and the working directory to run it in
+	Command() (string, string)
+	BuildArgs(url string, args []string) []string
+	ParseOutput(output string, videoID string)
+}
+
+// Finisher is implemented by backends that need to act after the process exited
+type Finisher interface {
+	Finish(videoID string)
+}
+
+var (
+	downloaders = make(map[string]Downloader)
+	// active counts the running downloads, so shutdown can wait for them.
+	// Once it waits, stopping keeps new downloads from being added.
+	active     sync.WaitGroup
+	activeLock sync.Mutex
+	stopping   bool
+)
+
+func Register(d Downloader) {
+	downloaders[d.Name()] = d
+}
+
+func Get(name string) Downloader {
+	return downloaders[name]
+}
+
+// StartDownload records the url with the named backend and blocks until the
+// process exits. When ctx is done the process is interrupted so it can still
+// finalize the recording.
+func StartDownload(ctx context.Context, name string, url string, args []string, channelLive *common.ChannelLive, outPath string) {
+	d := Get(name)
+	if d == nil {
+		golog.Error("[downloader] Unknown downloader: ", name)
+		return
+	}
+	if !begin(ctx) {
+		golog.Info("[downloader] shutting down, not starting ", channelLive.VideoID)
+		return
+	}
+	defer active.Done()
+	runWithRetry(ctx, d, url, args, channelLive, outPath, currentRetryPolicy())
+	notifyResult(channelLive.VideoID, url)
+}
+
+// begin counts a download as running, unless shutdown already started
+func begin(ctx context.Context) bool {
+	activeLock.Lock()
+	defer activeLock.Unlock()
+	if stopping || ctx.Err() != nil {
+		return false
+	}
+	active.Add(1)
+	return true
+}
+
+// notifyResult sends the outcome of a recording once no more attempts follow
+func notifyResult(videoID string, url string) {
+	common.DownloadJobsLock.Lock()
+	defer common.DownloadJobsLock.Unlock()
+
+	job, exists := common.DownloadJobs[videoID]
+	if !exists {
+		return
+	}
+	event := notifier.Event{Live: job.ChannelLive, Job: job.Snapshot(), URL: url}
+	switch job.Status {
+	case "Finished":
+		event.Type = notifier.EventDone
+	case "Error":
+		event.Type = notifier.EventError
+		event.Error = job.LastError
+	default:
+		return
+	}
+	notifier.Notify(event)
+}
+
+// progressInterval is how often a running recording reports its progress
+var progressInterval = 30 * time.Second
+
+func reportProgress(job *common.DownloadJob, url string, done <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		common.DownloadJobsLock.Lock()
+		notifier.Notify(notifier.Event{Type: notifier.EventProgress, Live: job.ChannelLive, Job: job.Snapshot(), URL: url})
+		common.DownloadJobsLock.Unlock()
+	}
+}
+
+// Wait blocks until every running download exited. Downloads still running
+// after the timeout are killed and Wait returns false.
+func Wait(timeout time.Duration) bool {
+	activeLock.Lock()
+	stopping = true
+	activeLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		killAll()
+		return false
+	}
+}
+
+func run(ctx context.Context, d Downloader, url string, args []string, channelLive *common.ChannelLive, outPath string, attempt int) {
+	module := d.Name()
+	executable, workingDirectory := d.Command()
+	allArgs := d.BuildArgs(url, args)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmdArgs := append([]string{"/C", executable}, allArgs...)
+		cmd = exec.CommandContext(ctx, "cmd", cmdArgs...)
+		golog.Debug("[", module, "] spawning jobs in windows: ", strings.Join(allArgs, " "))
+	} else {
+		cmd = exec.CommandContext(ctx, executable, allArgs...)
+		golog.Debug("[", module, "] spawning jobs: ", strings.Join(allArgs, " "))
+	}
+	cmd.Dir = workingDirectory
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		golog.Debug("[", module, "] Error creating StdoutPipe:", err)
+		return
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		golog.Debug("[", module, "] Error creating StderrPipe:", err)
+		return
+	}
+
+	common.AddDownloadJob(channelLive.VideoID, *channelLive, "Idle", "", outPath)
+	common.DownloadJobsLock.Lock()
+	job := common.DownloadJobs[channelLive.VideoID]
+	job.Downloader = module
+	job.Attempts = attempt
+	common.DownloadJobsLock.Unlock()
+
+	// Interrupt instead of killing on shutdown, so the recording gets muxed
+	cmd.Cancel = func() error {
+		common.DownloadJobsLock.Lock()
+		job.Stopped = true
+		common.DownloadJobsLock.Unlock()
+		return interrupt(cmd.Process)
+	}
+
+	// Start the command
+	if err := cmd.Start(); err != nil {
+		golog.Warn("[", module, "] Failed to start command: ", err)
+		common.DownloadJobsLock.Lock()
+		job.LastError = err.Error()
+		job.SetStatus("Error")
+		common.DownloadJobsLock.Unlock()
+		return
+	}
+	common.DownloadJobsLock.Lock()
+	job.Process = cmd.Process
+	common.DownloadJobsLock.Unlock()
+
+	progressDone := make(chan struct{})
+	go reportProgress(job, url, progressDone)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go readOutput(stdout, d, channelLive.VideoID, &wg)
+
+	// Read stderr (in case progress is written to stderr)
+	go readOutput(stderr, d, channelLive.VideoID, &wg)
+
+	wg.Wait()
+	close(progressDone)
+
+	waitErr := cmd.Wait()
+	if waitErr != nil {
+		golog.Warn("[", module, "] Error waiting for command to finish: ", waitErr)
+	}
+
+	common.DownloadJobsLock.Lock()
+	job.Process = nil
+	if job.Deleted {
+		removeDeleted(job, module, workingDirectory)
+		return
+	}
+	// Until the final status is saved, a delete only marks the job
+	job.Finalizing = true
+	common.DownloadJobsLock.Unlock()
+
+	if f, ok := d.(Finisher); ok {
+		f.Finish(channelLive.VideoID)
+	}
+
+	common.DownloadJobsLock.Lock()
+	job.Finalizing = false
+	if job.Deleted {
+		removeDeleted(job, module, workingDirectory)
+		return
+	}
+	if job.Stopped {
+		job.SetStatus("Interrupted")
+	} else if job.Status == "Error" {
+		job.LastError = job.Output
+		common.SaveDownloadJob(job)
+	} else if waitErr != nil && job.Status != "Finished" {
+		job.LastError = waitErr.Error()
+		if job.Output != "" {
+			job.LastError += ": " + job.Output
+		}
+		job.SetStatus("Error")
+	}
+	common.DownloadJobsLock.Unlock()
+
+	golog.Debug("[", module, "] Exited")
+}
+
+// removeDeleted removes a job that was deleted while its process ran and
+// releases DownloadJobsLock, which the caller must hold
+func removeDeleted(job *common.DownloadJob, module string, workingDirectory string) {
+	if common.DownloadJobs[job.VideoID] == job {
+		common.RemoveDownloadJob(job.VideoID)
+	}
+	common.DownloadJobsLock.Unlock()
+	removeTempFiles(workingDirectory, job.VideoID)
+	golog.Info("[", module, "] Deleted job: ", job.VideoID)
+}
+
+// isCurrent reports whether the job is still kept, so it may be saved.
+// The caller must hold DownloadJobsLock.
+func isCurrent(job *common.DownloadJob) bool {
+	return !job.Deleted && common.DownloadJobs[job.VideoID] == job
+}
+
+func readOutput(r io.Reader, d Downloader, videoID string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	outputBuffer := make([]byte, 4096)
+	for {
+		n, err := r.Read(outputBuffer)
+		if err != nil {
+			if err != io.EOF {
+				golog.Debug(fmt.Sprintf("[%s] Error reading output:", d.Name()), err)
+			}
+			return
+		}
+
+		output := string(outputBuffer[:n])
+		lines := strings.Split(output, "\n")
+
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			d.ParseOutput(line, videoID)
+		}
+		common.PublishProgress(videoID)
+	}
+}
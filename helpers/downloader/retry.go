@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"context"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// RetryPolicy decides how often a failed recording is restarted
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// ResetWindow is how long a recording has to run before a failure is
+	// counted as a new first attempt
+	ResetWindow time.Duration
+}
+
+func currentRetryPolicy() RetryPolicy {
+	retry := config.Get().Retry
+	policy := RetryPolicy{
+		MaxAttempts: retry.MaxAttempts,
+		Backoff:     time.Duration(retry.Backoff) * time.Second,
+		MaxBackoff:  time.Duration(retry.MaxBackoff) * time.Second,
+		ResetWindow: time.Duration(retry.ResetWindow) * time.Minute,
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Backoff <= 0 {
+		policy.Backoff = 10 * time.Second
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = 10 * time.Minute
+	}
+	return policy
+}
+
+// delay returns the backoff before the next attempt, doubling after every attempt
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+func runWithRetry(ctx context.Context, d Downloader, url string, args []string, channelLive *common.ChannelLive, outPath string, policy RetryPolicy) {
+	attempt := 1
+	for {
+		started := time.Now()
+		run(ctx, d, url, args, channelLive, outPath, attempt)
+
+		common.DownloadJobsLock.Lock()
+		job, exists := common.DownloadJobs[channelLive.VideoID]
+		failed := exists && job.Status == "Error" && !job.Stopped
+		common.DownloadJobsLock.Unlock()
+		if !failed {
+			return
+		}
+
+		if policy.ResetWindow > 0 && time.Since(started) > policy.ResetWindow {
+			attempt = 1
+		}
+		if attempt >= policy.MaxAttempts {
+			if policy.MaxAttempts > 1 {
+				golog.Warn("[", d.Name(), "] Giving up on ", channelLive.VideoID, " after ", attempt, " attempts")
+			}
+			return
+		}
+
+		delay := policy.delay(attempt)
+		golog.Info("[", d.Name(), "] Retrying ", channelLive.VideoID, " in ", delay, " (attempt ", attempt+1, " of ", policy.MaxAttempts, ")")
+		// Keep the job out of the Error state so the checker doesn't start a
+		// second recording while waiting
+		common.DownloadJobsLock.Lock()
+		if !isCurrent(job) {
+			common.DownloadJobsLock.Unlock()
+			return
+		}
+		job.SetStatus("Retrying")
+		common.DownloadJobsLock.Unlock()
+		select {
+		case <-ctx.Done():
+			common.DownloadJobsLock.Lock()
+			if isCurrent(job) {
+				job.SetStatus("Interrupted")
+			}
+			common.DownloadJobsLock.Unlock()
+			return
+		case <-time.After(delay):
+		}
+
+		common.DownloadJobsLock.Lock()
+		current := isCurrent(job)
+		stopped := job.Stopped
+		common.DownloadJobsLock.Unlock()
+		if !current || stopped {
+			return
+		}
+		attempt++
+	}
+}
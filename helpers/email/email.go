@@ -0,0 +1,273 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strconv"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"streamwatcher/helpers/notifier"
+	"strings"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// Notifier mails events over SMTP. Without an events list only errors are
+// mailed, the rest is left to the daily digest.
+type Notifier struct {
+	name   string
+	cfg    config.NotifierConfig
+	events []string
+}
+
+func init() {
+	notifier.Register("email", newNotifier)
+}
+
+func newNotifier(cfg config.NotifierConfig) (notifier.Notifier, error) {
+	if cfg.SMTPHost == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("email notifier needs a smtp_host, from and to")
+	}
+	n := &Notifier{name: cfg.Name, cfg: cfg, events: cfg.Events}
+	if n.name == "" {
+		n.name = "email"
+	}
+	if len(n.events) == 0 {
+		n.events = []string{notifier.EventError}
+	}
+	return n, nil
+}
+
+func (n *Notifier) Name() string {
+	return n.name
+}
+
+func (n *Notifier) Notify(event notifier.Event) error {
+	wanted := false
+	for _, eventType := range n.events {
+		wanted = wanted || eventType == event.Type
+	}
+	if !wanted {
+		return nil
+	}
+
+	subject := fmt.Sprintf("[%s] %s: %s", event.Label(), event.Live.ChannelName, event.Live.Title)
+	lines := []string{
+		"Channel: " + event.Live.ChannelName,
+		"Title:   " + event.Live.Title,
+		"URL:     " + event.URL,
+	}
+	if event.Job != nil {
+		lines = append(lines, "Status:  "+event.Job.Status)
+		if event.Job.FinalFile != "" {
+			lines = append(lines, "File:    "+event.Job.FinalFile)
+		}
+	}
+	if event.Error != "" {
+		lines = append(lines, "", "Error:", event.Error)
+	}
+	return n.send(subject, strings.Join(lines, "\n")+"\n")
+}
+
+var (
+	dialTimeout = 10 * time.Second
+	sendTimeout = time.Minute
+)
+
+// send mails the message, network errors like timeouts are retryable
+func (n *Notifier) send(subject string, body string) error {
+	err := n.deliver(subject, body)
+	var netErr net.Error
+	var retry *notifier.RetryError
+	if errors.As(err, &netErr) && !errors.As(err, &retry) {
+		return &notifier.RetryError{Err: err}
+	}
+	return err
+}
+
+func (n *Notifier) deliver(subject string, body string) error {
+	port := n.cfg.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(n.cfg.SMTPHost, strconv.Itoa(port))
+
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return &notifier.RetryError{Err: fmt.Errorf("[email] %w", err)}
+	}
+	// A server that stops answering fails the mail, so it is retried
+	conn.SetDeadline(time.Now().Add(sendTimeout))
+	client, err := smtp.NewClient(conn, n.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return &notifier.RetryError{Err: fmt.Errorf("[email] %w", err)}
+	}
+	defer client.Close()
+
+	if n.cfg.StartTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: n.cfg.SMTPHost}); err != nil {
+			return fmt.Errorf("[email] starttls: %w", err)
+		}
+	}
+	if n.cfg.Username != "" {
+		auth := smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, n.cfg.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("[email] auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(n.cfg.From); err != nil {
+		return fmt.Errorf("[email] %w", err)
+	}
+	for _, to := range n.cfg.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("[email] %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("[email] %w", err)
+	}
+	headers := []string{
+		"From: " + n.cfg.From,
+		"To: " + strings.Join(n.cfg.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	if _, err := w.Write([]byte(message)); err != nil {
+		return fmt.Errorf("[email] %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("[email] %w", err)
+	}
+	golog.Debug("[email] sent ", subject, " to ", strings.Join(n.cfg.To, ", "))
+	return client.Quit()
+}
+
+// StartDigest mails the daily digest of every email notifier that has one,
+// at its digest_time, until ctx is done
+func StartDigest(ctx context.Context) {
+	// Don't mail a digest for the time the watcher was not running
+	lastSent := make(map[string]time.Time)
+	started := time.Now()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		for _, cfg := range config.Get().Notifier {
+			if cfg.Type != "email" || !cfg.Digest {
+				continue
+			}
+			due, err := digestTime(cfg.DigestTime, now)
+			if err != nil {
+				golog.Warn("[email] ", err)
+				continue
+			}
+			key := cfg.Name + "/" + strings.Join(cfg.To, ",")
+			last, exists := lastSent[key]
+			if !exists {
+				last = started
+			}
+			if now.Before(due) || !last.Before(due) {
+				continue
+			}
+			lastSent[key] = now
+			go sendDigest(cfg, now)
+		}
+	}
+}
+
+// digestTime returns today's time of day the digest is due, "08:00" by default
+func digestTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		value = "08:00"
+	}
+	at, err := time.Parse("15:04", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid digest_time %q", value)
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location()), nil
+}
+
+func sendDigest(cfg config.NotifierConfig, now time.Time) {
+	n, err := newNotifier(cfg)
+	if err != nil {
+		golog.Warn("[email] ", err)
+		return
+	}
+	body := digest(now.Add(-24*time.Hour), now)
+	subject := "Stream watcher daily digest " + now.Format("2006-01-02")
+	if err := n.(*Notifier).send(subject, body); err != nil {
+		golog.Error("[email] failed to send the digest: ", err)
+	}
+}
+
+// digest lists the recordings that ended between since and until
+func digest(since time.Time, until time.Time) string {
+	common.DownloadJobsLock.Lock()
+	var jobs []common.DownloadJob
+	for _, job := range common.DownloadJobs {
+		if job.EndedAt.After(since) && !job.EndedAt.After(until) {
+			jobs = append(jobs, *job.Snapshot())
+		}
+	}
+	common.DownloadJobsLock.Unlock()
+
+	if len(jobs) == 0 {
+		return "Nothing was recorded in the last 24 hours.\n"
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].EndedAt.Before(jobs[j].EndedAt)
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d recordings ended in the last 24 hours.\n", len(jobs))
+	for _, job := range jobs {
+		fmt.Fprintf(&b, "\n%s: %s\n", job.ChannelLive.ChannelName, job.ChannelLive.Title)
+		fmt.Fprintf(&b, "  Status:   %s\n", job.Status)
+		fmt.Fprintf(&b, "  Duration: %s\n", duration(job).Round(time.Second))
+		if job.FinalFile != "" {
+			size := "missing"
+			if info, err := os.Stat(job.FinalFile); err == nil {
+				size = notifier.FormatSize(info.Size())
+			}
+			fmt.Fprintf(&b, "  File:     %s (%s)\n", job.FinalFile, size)
+		}
+		if job.Status == "Error" && job.LastError != "" {
+			fmt.Fprintf(&b, "  Error:    %s\n", job.LastError)
+		}
+	}
+	return b.String()
+}
+
+// duration is how long the recording ran, from its first status that wasn't
+// waiting for the stream until it ended
+func duration(job common.DownloadJob) time.Duration {
+	start := job.CreatedAt
+	for _, change := range job.StatusHistory {
+		if change.Status != "Idle" && change.Status != "Waiting" {
+			start = change.At
+			break
+		}
+	}
+	return job.EndedAt.Sub(start)
+}
@@ -0,0 +1,370 @@
+package email
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"streamwatcher/helpers/notifier"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mail struct {
+	from string
+	to   []string
+	data string
+}
+
+// serveSMTP is a local stand-in for an SMTP server that accepts every mail.
+// It returns the config of an email notifier using it, and the mails it got.
+func serveSMTP(t *testing.T) (config.NotifierConfig, chan mail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	mails := make(chan mail, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTPConn(conn, mails)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return config.NotifierConfig{
+		Type:     "email",
+		SMTPHost: addr.IP.String(),
+		SMTPPort: addr.Port,
+		From:     "watcher@example.com",
+		To:       []string{"me@example.com", "backup@example.com"},
+	}, mails
+}
+
+func serveSMTPConn(conn net.Conn, mails chan mail) {
+	text := textproto.NewConn(conn)
+	defer text.Close()
+	text.PrintfLine("220 smtp.test ESMTP")
+
+	var current mail
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 smtp.test")
+		case "MAIL":
+			current = mail{from: strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			current.to = append(current.to, strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			current.data = string(data)
+			// Before the reply, so the mail is there once the client returns
+			mails <- current
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// parseMail splits a received message into its headers and body
+func parseMail(t *testing.T, data string) (textproto.MIMEHeader, string) {
+	t.Helper()
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(data)))
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader.R)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header, string(body)
+}
+
+func TestNotifyOnlyErrorsByDefault(t *testing.T) {
+	cfg, mails := serveSMTP(t)
+	n, err := newNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, eventType := range []string{notifier.EventRecording, notifier.EventWaiting, notifier.EventProgress, notifier.EventDone} {
+		if err := n.Notify(notifier.Event{Type: eventType}); err != nil {
+			t.Fatalf("%s: %v", eventType, err)
+		}
+	}
+	if len(mails) != 0 {
+		t.Fatalf("%d mails for events that aren't mailed, want 0", len(mails))
+	}
+
+	event := notifier.Event{
+		Type: notifier.EventError,
+		Live: common.ChannelLive{ChannelName: "Example Channel", Title: "【Karaoke】 Songs"},
+		Job: &common.DownloadJob{
+			Status:    "Error",
+			FinalFile: "/videos/example.mp4",
+		},
+		URL:   "https://www.youtube.com/watch?v=live0000001",
+		Error: "ytarchive exited with 1\n.line starting with a dot",
+	}
+	if err := n.Notify(event); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 {
+		t.Fatalf("%d mails, want 1", len(mails))
+	}
+	m := <-mails
+	if m.from != "watcher@example.com" || strings.Join(m.to, ",") != "me@example.com,backup@example.com" {
+		t.Fatalf("envelope from %q to %v", m.from, m.to)
+	}
+
+	header, body := parseMail(t, m.data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"From":         "watcher@example.com",
+		"To":           "me@example.com, backup@example.com",
+		"Content-Type": "text/plain; charset=utf-8",
+		"Mime-Version": "1.0",
+	}
+	for key, value := range want {
+		if header.Get(key) != value {
+			t.Errorf("%s = %q, want %q", key, header.Get(key), value)
+		}
+	}
+	if subject != "[Error] Example Channel: 【Karaoke】 Songs" {
+		t.Errorf("Subject = %q", subject)
+	}
+	if _, err := time.Parse(time.RFC1123Z, header.Get("Date")); err != nil {
+		t.Errorf("Date = %q: %v", header.Get("Date"), err)
+	}
+
+	wantBody := strings.Join([]string{
+		"Channel: Example Channel",
+		"Title:   【Karaoke】 Songs",
+		"URL:     https://www.youtube.com/watch?v=live0000001",
+		"Status:  Error",
+		"File:    /videos/example.mp4",
+		"",
+		"Error:",
+		"ytarchive exited with 1",
+		".line starting with a dot",
+	}, "\n") + "\n"
+	if body != wantBody {
+		t.Errorf("body = %q, want %q", body, wantBody)
+	}
+}
+
+func TestNotifyConfiguredEvents(t *testing.T) {
+	cfg, mails := serveSMTP(t)
+	cfg.Events = []string{notifier.EventDone}
+	n, err := newNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Notify(notifier.Event{Type: notifier.EventError}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(notifier.Event{Type: notifier.EventDone, Live: common.ChannelLive{ChannelName: "Example Channel", Title: "Done"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 {
+		t.Fatalf("%d mails, want the Done event only", len(mails))
+	}
+	header, _ := parseMail(t, (<-mails).data)
+	if subject := header.Get("Subject"); !strings.HasPrefix(subject, "[Done]") {
+		t.Fatalf("Subject = %q, want the Done event", subject)
+	}
+}
+
+func TestNotifyStalledServerIsRetried(t *testing.T) {
+	// A server that accepts the connection but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+	previous := sendTimeout
+	sendTimeout = 200 * time.Millisecond
+	defer func() { sendTimeout = previous }()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	n, err := newNotifier(config.NotifierConfig{SMTPHost: addr.IP.String(), SMTPPort: addr.Port, From: "watcher@example.com", To: []string{"me@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Notify(notifier.Event{Type: notifier.EventError})
+	var retry *notifier.RetryError
+	if !errors.As(err, &retry) {
+		t.Fatalf("got %v, want a RetryError", err)
+	}
+}
+
+func setJobs(t *testing.T, jobs ...*common.DownloadJob) {
+	t.Helper()
+	common.DownloadJobsLock.Lock()
+	previous := common.DownloadJobs
+	common.DownloadJobs = make(map[string]*common.DownloadJob)
+	for _, job := range jobs {
+		common.DownloadJobs[job.VideoID] = job
+	}
+	common.DownloadJobsLock.Unlock()
+	t.Cleanup(func() {
+		common.DownloadJobsLock.Lock()
+		common.DownloadJobs = previous
+		common.DownloadJobsLock.Unlock()
+	})
+}
+
+func TestDigest(t *testing.T) {
+	until := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	since := until.Add(-24 * time.Hour)
+
+	finalFile := filepath.Join(t.TempDir(), "karaoke.mp4")
+	if err := os.WriteFile(finalFile, make([]byte, 3*1024*1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setJobs(t,
+		&common.DownloadJob{
+			VideoID:     "recorded",
+			ChannelLive: common.ChannelLive{ChannelName: "Example Channel", Title: "Karaoke"},
+			Status:      "Finished",
+			FinalFile:   finalFile,
+			CreatedAt:   until.Add(-6 * time.Hour),
+			// The wait for the stream to start isn't counted
+			StatusHistory: []common.StatusChange{
+				{Status: "Waiting", At: until.Add(-6 * time.Hour)},
+				{Status: "Recording", At: until.Add(-4 * time.Hour)},
+				{Status: "Muxing", At: until.Add(-2*time.Hour - 30*time.Minute)},
+				{Status: "Finished", At: until.Add(-2 * time.Hour)},
+			},
+			EndedAt: until.Add(-2 * time.Hour),
+		},
+		&common.DownloadJob{
+			VideoID:     "failed",
+			ChannelLive: common.ChannelLive{ChannelName: "Other Channel", Title: "Minecraft"},
+			Status:      "Error",
+			FinalFile:   "/videos/missing.mp4",
+			CreatedAt:   until.Add(-23 * time.Hour),
+			EndedAt:     until.Add(-22*time.Hour - 15*time.Minute - 30*time.Second),
+			LastError:   "stream is private",
+		},
+		&common.DownloadJob{
+			VideoID:     "before",
+			ChannelLive: common.ChannelLive{ChannelName: "Example Channel", Title: "Yesterday"},
+			Status:      "Finished",
+			EndedAt:     since,
+		},
+		&common.DownloadJob{
+			VideoID:     "after",
+			ChannelLive: common.ChannelLive{ChannelName: "Example Channel", Title: "Later"},
+			Status:      "Finished",
+			EndedAt:     until.Add(time.Second),
+		},
+		&common.DownloadJob{
+			VideoID:     "running",
+			ChannelLive: common.ChannelLive{ChannelName: "Example Channel", Title: "Running"},
+			Status:      "Recording",
+		},
+	)
+
+	want := strings.Join([]string{
+		"2 recordings ended in the last 24 hours.",
+		"",
+		"Other Channel: Minecraft",
+		"  Status:   Error",
+		"  Duration: 44m30s",
+		"  File:     /videos/missing.mp4 (missing)",
+		"  Error:    stream is private",
+		"",
+		"Example Channel: Karaoke",
+		"  Status:   Finished",
+		"  Duration: 2h0m0s",
+		"  File:     " + finalFile + " (3.0 MiB)",
+	}, "\n") + "\n"
+	if got := digest(since, until); got != want {
+		t.Errorf("digest =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := digest(until.Add(time.Hour), until.Add(2*time.Hour)); got != "Nothing was recorded in the last 24 hours.\n" {
+		t.Errorf("empty digest = %q", got)
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	cfg, mails := serveSMTP(t)
+	setJobs(t)
+	now := time.Date(2026, 3, 2, 8, 0, 0, 0, time.Local)
+
+	sendDigest(cfg, now)
+	if len(mails) != 1 {
+		t.Fatalf("%d mails, want the digest", len(mails))
+	}
+	header, body := parseMail(t, (<-mails).data)
+	if subject := header.Get("Subject"); subject != "Stream watcher daily digest 2026-03-02" {
+		t.Errorf("Subject = %q", subject)
+	}
+	if body != "Nothing was recorded in the last 24 hours.\n" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestDigestTime(t *testing.T) {
+	now := time.Date(2026, 3, 2, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)},
+		{"21:45", time.Date(2026, 3, 2, 21, 45, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := digestTime(tt.value, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("digestTime(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	if _, err := digestTime("8am", now); err == nil {
+		t.Error("expected an error for an invalid digest_time")
+	}
+}
@@ -0,0 +1,70 @@
+package jobstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"streamwatcher/common"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var jobsBucket = []byte("jobs")
+
+// BoltStore keeps download jobs in a bbolt database, one JSON document per video ID
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func Open(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("[jobstore] failed to open database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("[jobstore] failed to create bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Load() ([]*common.DownloadJob, error) {
+	var jobs []*common.DownloadJob
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job common.DownloadJob
+			if err := json.Unmarshal(v, &job); err != nil {
+				return fmt.Errorf("[jobstore] failed to decode job %s: %w", k, err)
+			}
+			jobs = append(jobs, &job)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+func (s *BoltStore) Save(job *common.DownloadJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(job.VideoID), data)
+	})
+}
+
+func (s *BoltStore) Delete(videoID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete([]byte(videoID))
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,142 @@
+package notifier
+
+import (
+	"fmt"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+const (
+	EventRecording = "Recording"
+	EventWaiting   = "Waiting"
+	EventProgress  = "Progress"
+	EventDone      = "Done"
+	EventError     = "Error"
+)
+
+// Event is something that happened to a stream or its recording
+type Event struct {
+	Type string
+	Live common.ChannelLive
+	// Job is a snapshot of the recording, nil before the download started
+	Job   *common.DownloadJob
+	URL   string
+	Error string
+	At    time.Time
+}
+
+// Label names the event type for people. The download of a VOD goes through
+// the same events as a live recording, so it is marked.
+func (e Event) Label() string {
+	if e.Live.VOD {
+		return e.Type + " VOD"
+	}
+	return e.Type
+}
+
+// Notifier sends events to a single destination
+type Notifier interface {
+	Name() string
+	Notify(event Event) error
+}
+
+// Tracker is implemented by notifiers that keep one message per recording up
+// to date. Only they get Progress events, unless a notifier lists it in events.
+type Tracker interface {
+	TracksProgress() bool
+}
+
+// Factory creates a notifier from its [[notifier]] config entry
+type Factory func(cfg config.NotifierConfig) (Notifier, error)
+
+var factories = make(map[string]Factory)
+
+// Register makes a notifier type available to the [[notifier]] config
+func Register(notifierType string, factory Factory) {
+	factories[notifierType] = factory
+}
+
+// configured returns the notifiers of the config. The legacy [discord]
+// section is kept as a discord notifier for every event.
+func configured() []config.NotifierConfig {
+	cfg := config.Get()
+	configs := cfg.Notifier
+	if cfg.Discord.Notify && cfg.Discord.Webhook != "" {
+		configs = append([]config.NotifierConfig{{
+			Type: "discord",
+			Name: "discord",
+			URL:  cfg.Discord.Webhook,
+		}}, configs...)
+	}
+	return configs
+}
+
+func wants(cfg config.NotifierConfig, eventType string) bool {
+	if len(cfg.Events) == 0 {
+		return true
+	}
+	for _, event := range cfg.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// New creates the notifier of a config entry
+func New(cfg config.NotifierConfig) (Notifier, error) {
+	factory, exists := factories[cfg.Type]
+	if !exists {
+		return nil, fmt.Errorf("unknown notifier type %q", cfg.Type)
+	}
+	return factory(cfg)
+}
+
+// Notify queues the event for every notifier that wants it. Each notifier
+// sends its events in order in the background, so it is safe to call while
+// holding DownloadJobsLock.
+func Notify(event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now().UTC()
+	}
+	for _, cfg := range configured() {
+		if !wants(cfg, event.Type) {
+			continue
+		}
+		n, err := New(cfg)
+		if err != nil {
+			golog.Warn("[notifier] ", cfg.Name, ": ", err)
+			continue
+		}
+		if tracker, ok := n.(Tracker); event.Type == EventProgress && len(cfg.Events) == 0 && (!ok || !tracker.TracksProgress()) {
+			continue
+		}
+		enqueue(fmt.Sprint(cfg.Type, "/", cfg.Name, "/", cfg.URL, "/", cfg.ChatID), n, event)
+	}
+}
+
+// FormatSize formats a file size in binary units, like "1.5 GiB"
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// Truncate cuts text to length runes, marking the cut with an ellipsis
+func Truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length]) + "…"
+}
@@ -0,0 +1,31 @@
+package notifier
+
+import "testing"
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.size); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := Truncate("short", 10); got != "short" {
+		t.Errorf("Truncate kept %q, want it unchanged", got)
+	}
+	// Runes are counted, not bytes
+	if got := Truncate("【配信】カラオケ", 4); got != "【配信】…" {
+		t.Errorf("Truncate = %q, want %q", got, "【配信】…")
+	}
+}
@@ -0,0 +1,166 @@
+package notifier
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// RetryError is returned by a notifier when sending may work later, like on a
+// rate limit or a server error. RetryAfter is the wait the server asked for.
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// CheckResponse turns an unsuccessful response into an error, retryable for
+// 429 and 5xx. The Retry-After header is honored.
+func CheckResponse(name string, resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("[%s] returned %d: %s", name, resp.StatusCode, body)
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return err
+	}
+	retry := &RetryError{Err: err}
+	if seconds, parseErr := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); parseErr == nil {
+		retry.RetryAfter = time.Duration(seconds * float64(time.Second))
+	}
+	return retry
+}
+
+// Client is the http client of the notifiers. Its timeout turns a hanging
+// endpoint into a retryable error instead of stalling the queue.
+var Client = &http.Client{Timeout: 30 * time.Second}
+
+var (
+	maxAttempts = 5
+	backoff     = 2 * time.Second
+	maxBackoff  = time.Minute
+	queueSize   = 256
+)
+
+// queue sends the events of one notifier in order, retrying failed ones.
+// Only the latest Progress of a recording is kept while it waits.
+type queue struct {
+	lock   sync.Mutex
+	ready  *sync.Cond
+	events []queuedEvent
+}
+
+type queuedEvent struct {
+	notifier Notifier
+	event    Event
+}
+
+var (
+	queues     = make(map[string]*queue)
+	queuesLock sync.Mutex
+	// pending counts the queued events, so shutdown can wait for them
+	pending sync.WaitGroup
+)
+
+// enqueue hands the event to the worker of the notifier without blocking.
+// Once the queue is full, events are dropped, except for Done and Error.
+func enqueue(key string, n Notifier, event Event) {
+	queuesLock.Lock()
+	q, exists := queues[key]
+	if !exists {
+		q = &queue{}
+		q.ready = sync.NewCond(&q.lock)
+		queues[key] = q
+		go q.run()
+	}
+	queuesLock.Unlock()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if event.Type == EventProgress {
+		for i, queued := range q.events {
+			if queued.event.Type == EventProgress && queued.event.Live.VideoID == event.Live.VideoID {
+				q.events[i] = queuedEvent{notifier: n, event: event}
+				return
+			}
+		}
+	}
+	if len(q.events) >= queueSize && event.Type != EventDone && event.Type != EventError {
+		golog.Error("[notifier] ", n.Name(), " queue is full, dropping ", event.Type, " of ", event.Live.VideoID)
+		return
+	}
+	pending.Add(1)
+	q.events = append(q.events, queuedEvent{notifier: n, event: event})
+	q.ready.Signal()
+}
+
+func (q *queue) run() {
+	for {
+		q.lock.Lock()
+		for len(q.events) == 0 {
+			q.ready.Wait()
+		}
+		queued := q.events[0]
+		q.events = q.events[1:]
+		q.lock.Unlock()
+
+		send(queued.notifier, queued.event)
+		pending.Done()
+	}
+}
+
+func send(n Notifier, event Event) {
+	delay := backoff
+	for attempt := 1; ; attempt++ {
+		err := n.Notify(event)
+		if err == nil {
+			return
+		}
+		var retry *RetryError
+		var urlErr *url.Error
+		retryable := errors.As(err, &retry) || errors.As(err, &urlErr)
+		if !retryable || attempt >= maxAttempts {
+			golog.Error("[notifier] ", n.Name(), " failed to send ", event.Type, " of ", event.Live.VideoID, " after ", attempt, " attempts: ", err)
+			return
+		}
+
+		wait := delay
+		if retry != nil && retry.RetryAfter > 0 {
+			wait = retry.RetryAfter
+		}
+		golog.Warn("[notifier] ", n.Name(), " failed to send ", event.Type, ", retrying in ", wait, ": ", err)
+		time.Sleep(wait)
+		delay = min(delay*2, maxBackoff)
+	}
+}
+
+// Wait blocks until the queued events are sent, or the timeout passed
+func Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
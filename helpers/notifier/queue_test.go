@@ -0,0 +1,90 @@
+package notifier
+
+import (
+	"reflect"
+	"streamwatcher/common"
+	"testing"
+	"time"
+)
+
+// blockedNotifier records the events it got, holding the first one until
+// release is closed so the following ones stay queued
+type blockedNotifier struct {
+	started chan struct{}
+	release chan struct{}
+	sent    chan string
+}
+
+func newBlockedNotifier() *blockedNotifier {
+	return &blockedNotifier{started: make(chan struct{}), release: make(chan struct{}), sent: make(chan string, 16)}
+}
+
+func (n *blockedNotifier) Name() string { return "blocked" }
+
+func (n *blockedNotifier) Notify(event Event) error {
+	select {
+	case <-n.started:
+	default:
+		close(n.started)
+		<-n.release
+	}
+	n.sent <- event.Type + " " + event.Live.VideoID + " " + event.URL
+	return nil
+}
+
+func queueEvent(t *testing.T, key string, n Notifier, eventType string, videoID string, url string) {
+	t.Helper()
+	enqueue(key, n, Event{Type: eventType, Live: common.ChannelLive{VideoID: videoID}, URL: url})
+}
+
+func receiveAll(t *testing.T, n *blockedNotifier) []string {
+	t.Helper()
+	if !Wait(time.Second) {
+		t.Fatal("queue was not sent")
+	}
+	close(n.sent)
+	var sent []string
+	for event := range n.sent {
+		sent = append(sent, event)
+	}
+	return sent
+}
+
+func TestQueueKeepsLatestProgress(t *testing.T) {
+	n := newBlockedNotifier()
+	queueEvent(t, t.Name(), n, EventRecording, "a", "")
+	<-n.started
+	queueEvent(t, t.Name(), n, EventProgress, "a", "1")
+	queueEvent(t, t.Name(), n, EventProgress, "b", "1")
+	queueEvent(t, t.Name(), n, EventProgress, "a", "2")
+	queueEvent(t, t.Name(), n, EventDone, "a", "")
+	queueEvent(t, t.Name(), n, EventProgress, "a", "3")
+	close(n.release)
+
+	want := []string{"Recording a ", "Progress a 3", "Progress b 1", "Done a "}
+	if sent := receiveAll(t, n); !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent = %q, want %q", sent, want)
+	}
+}
+
+func TestQueueFullKeepsDoneAndError(t *testing.T) {
+	previous := queueSize
+	queueSize = 2
+	t.Cleanup(func() { queueSize = previous })
+
+	n := newBlockedNotifier()
+	queueEvent(t, t.Name(), n, EventRecording, "a", "")
+	<-n.started
+	queueEvent(t, t.Name(), n, EventRecording, "b", "")
+	queueEvent(t, t.Name(), n, EventProgress, "b", "")
+	queueEvent(t, t.Name(), n, EventRecording, "c", "")
+	queueEvent(t, t.Name(), n, EventWaiting, "d", "")
+	queueEvent(t, t.Name(), n, EventError, "a", "")
+	queueEvent(t, t.Name(), n, EventDone, "b", "")
+	close(n.release)
+
+	want := []string{"Recording a ", "Recording b ", "Progress b ", "Error a ", "Done b "}
+	if sent := receiveAll(t, n); !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent = %q, want %q", sent, want)
+	}
+}
@@ -1,14 +1,11 @@
 package streamlink
 
 import (
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"streamwatcher/common"
 	"streamwatcher/config"
-	"streamwatcher/helpers/discord"
+	"streamwatcher/helpers/downloader"
 	"strings"
-	"sync"
 
 	"github.com/kataras/golog"
 )
@@ -19,65 +16,58 @@ const (
 
 var waitOutputPath bool
 
-func StartDownload(url string, args []string, channelLive *common.ChannelLive, outPath string) {
-	var cmd *exec.Cmd
+// Downloader records Twitch streams with streamlink
+type Downloader struct{}
+
+func init() {
+	downloader.Register(Downloader{})
+}
+
+func (Downloader) Name() string {
+	return "streamlink"
+}
+
+func (Downloader) Command() (string, string) {
+	return config.Get().Streamlink.ExecutablePath, config.Get().Streamlink.WorkingDirectory
+}
+
+func (Downloader) BuildArgs(url string, args []string) []string {
 	var allArgs []string
 	allArgs = append(allArgs, args...)
 	allArgs = append(allArgs, url)
-	allArgs = append(allArgs, config.AppConfig.Streamlink.Args...)
-
-	if runtime.GOOS == "windows" {
-		cmdArgs := append([]string{"/C", config.AppConfig.Streamlink.ExecutablePath}, allArgs...)
-		cmd = exec.Command("cmd", cmdArgs...)
-		cmd.Dir = config.AppConfig.Streamlink.WorkingDirectory
-		golog.Debug(moduleName, "spawning jobs in windows: ", strings.Join(allArgs, " "))
-	} else {
-		cmd = exec.Command(config.AppConfig.Streamlink.ExecutablePath, allArgs...)
-		cmd.Dir = config.AppConfig.Streamlink.WorkingDirectory
-		golog.Debug(moduleName, "spawning jobs: ", strings.Join(allArgs, " "))
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		golog.Debug(moduleName, "Error creating StdoutPipe:", err)
-		return
-	}
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		golog.Debug(moduleName, "Error creating StderrPipe:", err)
-		return
-	}
+	allArgs = append(allArgs, config.Get().Streamlink.Args...)
+	return allArgs
+}
 
-	common.AddDownloadJob(channelLive.VideoID, *channelLive, "Idle", "", outPath)
+func (Downloader) ParseOutput(output string, videoID string) {
+	parseOutput(output, videoID)
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		golog.Warn(moduleName, "Failed to start command: ", err)
+// Finish moves the recording out of the working directory, streamlink only
+// reports where it writes the file
+func (Downloader) Finish(videoID string) {
+	common.DownloadJobsLock.Lock()
+	job, exists := common.DownloadJobs[videoID]
+	if !exists || job.Deleted {
+		common.DownloadJobsLock.Unlock()
 		return
 	}
-	outputBuffer := make([]byte, 4096)
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go common.ReadStdout(stdout, outputBuffer, parseOutput, channelLive.VideoID, &wg, "yt-dlp")
-
-	// Read stderr (in case progress is written to stderr)
-	go common.ReadStderr(stderr, outputBuffer, parseOutput, channelLive.VideoID, &wg, "yt-dlp")
+	source := job.FinalFile
+	target := job.OutPath + "/" + filepath.Base(source)
+	common.DownloadJobsLock.Unlock()
 
-	wg.Wait()
-
-	if err := cmd.Wait(); err != nil {
-		golog.Warn(moduleName, "Error waiting for command to finish: ", err)
+	// Moving may copy the whole recording, so it runs without the lock
+	if err := common.MoveFile(source, target); err != nil {
+		golog.Warn(moduleName, "Failed to move file: ", err)
 	}
 
-	filename := filepath.Base(common.DownloadJobs[channelLive.VideoID].FinalFile)
-	if err := common.MoveFile(common.DownloadJobs[channelLive.VideoID].FinalFile, common.DownloadJobs[channelLive.VideoID].OutPath+"/"+filename); err != nil {
-		golog.Warn(moduleName, "Failed to move file: ", err)
+	common.DownloadJobsLock.Lock()
+	defer common.DownloadJobsLock.Unlock()
+	if common.DownloadJobs[videoID] != job || job.Deleted {
+		return
 	}
-	common.DownloadJobs[channelLive.VideoID].FinalFile = common.DownloadJobs[channelLive.VideoID].OutPath + "/" + filename
-	discord.SendNotificationWebhook(common.DownloadJobs[channelLive.VideoID].ChannelLive.ChannelName, common.DownloadJobs[channelLive.VideoID].ChannelLive.Title, "https://twitch.tv"+common.DownloadJobs[channelLive.VideoID].ChannelLive.ChannelName, common.DownloadJobs[channelLive.VideoID].ChannelLive.ThumbnailUrl, "Done")
+	job.FinalFile = target
+	common.SaveDownloadJob(job)
 
 	golog.Debug(moduleName, "Download finished")
 }
@@ -91,7 +81,7 @@ func parseOutput(output string, videoId string) {
 	if waitOutputPath {
 		common.DownloadJobs[videoId].FinalFile = output
 		golog.Info(moduleName, "output path: ", output)
-		common.DownloadJobs[videoId].Status = "Downloading"
+		common.DownloadJobs[videoId].SetStatus("Downloading")
 		golog.Info(moduleName, "Downloading: ", output)
 		waitOutputPath = false
 	}
@@ -100,7 +90,7 @@ func parseOutput(output string, videoId string) {
 	}
 
 	if strings.Contains(output, "Closing currently open stream...") {
-		common.DownloadJobs[videoId].Status = "Finished"
+		common.DownloadJobs[videoId].SetStatus("Finished")
 		common.DownloadJobs[videoId].Output = output
 	}
 }
@@ -0,0 +1,165 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"streamwatcher/config"
+	"streamwatcher/helpers/notifier"
+	"strings"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+var headlines = map[string]string{
+	notifier.EventRecording: "🔴 Recording",
+	notifier.EventWaiting:   "⏳ Waiting",
+	notifier.EventDone:      "✅ Finished",
+	notifier.EventError:     "❌ Error",
+}
+
+type inlineButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+type replyMarkup struct {
+	InlineKeyboard [][]inlineButton `json:"inline_keyboard"`
+}
+
+type message struct {
+	ChatID      string       `json:"chat_id"`
+	Photo       string       `json:"photo,omitempty"`
+	Caption     string       `json:"caption,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	ParseMode   string       `json:"parse_mode"`
+	ReplyMarkup *replyMarkup `json:"reply_markup,omitempty"`
+}
+
+// Notifier sends events through the Telegram Bot API
+type Notifier struct {
+	name   string
+	apiURL string
+	token  string
+	chatID string
+}
+
+func init() {
+	notifier.Register("telegram", func(cfg config.NotifierConfig) (notifier.Notifier, error) {
+		if cfg.BotToken == "" || cfg.ChatID == "" {
+			return nil, fmt.Errorf("telegram notifier needs a bot_token and a chat_id")
+		}
+		n := &Notifier{name: cfg.Name, apiURL: cfg.APIURL, token: cfg.BotToken, chatID: cfg.ChatID}
+		if n.name == "" {
+			n.name = "telegram"
+		}
+		if n.apiURL == "" {
+			n.apiURL = "https://api.telegram.org"
+		}
+		return n, nil
+	})
+}
+
+func (n *Notifier) Name() string {
+	return n.name
+}
+
+func (n *Notifier) Notify(event notifier.Event) error {
+	msg := message{
+		ChatID:    n.chatID,
+		ParseMode: "HTML",
+	}
+	if event.URL != "" {
+		button := "Open stream"
+		if event.Live.VOD {
+			button = "Open VOD"
+		}
+		msg.ReplyMarkup = &replyMarkup{InlineKeyboard: [][]inlineButton{{{Text: button, URL: event.URL}}}}
+	}
+
+	text := caption(event)
+	if event.Live.ThumbnailUrl != "" {
+		photo := msg
+		photo.Photo = event.Live.ThumbnailUrl
+		photo.Caption = notifier.Truncate(text, 1024)
+		err := n.call("sendPhoto", photo)
+		var retry *notifier.RetryError
+		if err == nil || errors.As(err, &retry) {
+			return err
+		}
+		// Telegram can fail to fetch the thumbnail, still send the text
+		golog.Debug(err)
+	}
+	msg.Text = text
+	return n.call("sendMessage", msg)
+}
+
+// caption builds the HTML text of an event
+func caption(event notifier.Event) string {
+	headline, exists := headlines[event.Type]
+	if !exists {
+		headline = event.Type
+	}
+	if event.Live.VOD {
+		headline += " VOD"
+	}
+	lines := []string{
+		"<b>" + html.EscapeString(headline) + "</b> " + html.EscapeString(event.Live.ChannelName),
+		html.EscapeString(event.Live.Title),
+	}
+	if event.Type == notifier.EventDone && event.Job != nil && event.Job.FinalFile != "" {
+		if info, err := os.Stat(event.Job.FinalFile); err == nil {
+			lines = append(lines, "Size: "+notifier.FormatSize(info.Size()))
+		}
+	}
+	if event.Error != "" {
+		lines = append(lines, "<code>"+html.EscapeString(notifier.Truncate(event.Error, 500))+"</code>")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (n *Notifier) call(method string, msg message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := notifier.Client.Post(fmt.Sprintf("%s/bot%s/%s", n.apiURL, n.token, method), "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The url contains the token, don't log it
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return &notifier.RetryError{Err: fmt.Errorf("[telegram] %s failed: %w", method, err)}
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &notifier.RetryError{
+			Err:        fmt.Errorf("[telegram] %s returned %d: %s", method, resp.StatusCode, result.Description),
+			RetryAfter: time.Duration(result.Parameters.RetryAfter) * time.Second,
+		}
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("[telegram] %s returned %d", method, resp.StatusCode)
+	}
+	if !result.OK {
+		return fmt.Errorf("[telegram] %s failed: %s", method, result.Description)
+	}
+	golog.Debug("[telegram] ", method, " sent to ", n.name)
+	return nil
+}
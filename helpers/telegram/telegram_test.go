@@ -0,0 +1,185 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"streamwatcher/common"
+	"streamwatcher/helpers/notifier"
+	"strings"
+	"testing"
+	"time"
+)
+
+type call struct {
+	method string
+	msg    message
+}
+
+// serveBotAPI stands in for api.telegram.org. Every call is sent on the
+// returned channel and answered with responses[method], or ok.
+func serveBotAPI(t *testing.T, responses map[string]string) (*Notifier, chan call) {
+	t.Helper()
+	calls := make(chan call, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, found := strings.CutPrefix(r.URL.Path, "/botbot-token/")
+		var msg message
+		if !found || json.NewDecoder(r.Body).Decode(&msg) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		calls <- call{method, msg}
+
+		response, exists := responses[method]
+		if !exists {
+			response = `{"ok":true,"result":{}}`
+		}
+		var result struct {
+			ErrorCode int `json:"error_code"`
+		}
+		json.Unmarshal([]byte(response), &result)
+		if result.ErrorCode != 0 {
+			w.WriteHeader(result.ErrorCode)
+		}
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return &Notifier{name: "telegram", apiURL: server.URL, token: "bot-token", chatID: "-100123"}, calls
+}
+
+func recordingEvent() notifier.Event {
+	return notifier.Event{
+		Type: notifier.EventRecording,
+		Live: common.ChannelLive{
+			Title:        "Karaoke <3 & chat",
+			ThumbnailUrl: "https://i.ytimg.example/vi/live0000001/maxresdefault.jpg",
+			ChannelName:  "Example Channel",
+		},
+		URL: "https://www.youtube.com/watch?v=live0000001",
+	}
+}
+
+func TestNotifySendsPhoto(t *testing.T) {
+	n, calls := serveBotAPI(t, nil)
+	if err := n.Notify(recordingEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 1 {
+		t.Fatalf("%d calls, want only sendPhoto", len(calls))
+	}
+	c := <-calls
+	if c.method != "sendPhoto" || c.msg.ChatID != "-100123" || c.msg.ParseMode != "HTML" || c.msg.Photo != "https://i.ytimg.example/vi/live0000001/maxresdefault.jpg" {
+		t.Errorf("%s %+v", c.method, c.msg)
+	}
+	if want := "<b>🔴 Recording</b> Example Channel\nKaraoke &lt;3 &amp; chat"; c.msg.Caption != want {
+		t.Errorf("Caption = %q, want %q", c.msg.Caption, want)
+	}
+	if c.msg.Text != "" {
+		t.Errorf("Text = %q, want it empty with a photo", c.msg.Text)
+	}
+	if c.msg.ReplyMarkup == nil || c.msg.ReplyMarkup.InlineKeyboard[0][0].URL != "https://www.youtube.com/watch?v=live0000001" {
+		t.Errorf("ReplyMarkup = %+v, want a button to the stream", c.msg.ReplyMarkup)
+	}
+}
+
+func TestNotifyLabelsVOD(t *testing.T) {
+	n, calls := serveBotAPI(t, nil)
+	event := recordingEvent()
+	event.Live.VOD = true
+	if err := n.Notify(event); err != nil {
+		t.Fatal(err)
+	}
+
+	c := <-calls
+	if want := "<b>🔴 Recording VOD</b> Example Channel\nKaraoke &lt;3 &amp; chat"; c.msg.Caption != want {
+		t.Errorf("Caption = %q, want %q", c.msg.Caption, want)
+	}
+	if c.msg.ReplyMarkup == nil || c.msg.ReplyMarkup.InlineKeyboard[0][0].Text != "Open VOD" {
+		t.Errorf("ReplyMarkup = %+v, want a button to the VOD", c.msg.ReplyMarkup)
+	}
+}
+
+func TestNotifyFallsBackToMessage(t *testing.T) {
+	n, calls := serveBotAPI(t, map[string]string{
+		"sendPhoto": `{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier/HTTP URL specified"}`,
+	})
+	if err := n.Notify(recordingEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("%d calls, want sendPhoto then sendMessage", len(calls))
+	}
+	photo, fallback := <-calls, <-calls
+	if photo.method != "sendPhoto" || fallback.method != "sendMessage" {
+		t.Fatalf("called %s then %s, want sendPhoto then sendMessage", photo.method, fallback.method)
+	}
+	if fallback.msg.Photo != "" || fallback.msg.Caption != "" || fallback.msg.Text != photo.msg.Caption {
+		t.Errorf("fallback message = %+v, want the caption as text", fallback.msg)
+	}
+	if fallback.msg.ReplyMarkup == nil {
+		t.Error("fallback message lost the stream button")
+	}
+}
+
+func TestNotifyRateLimited(t *testing.T) {
+	n, calls := serveBotAPI(t, map[string]string{
+		"sendPhoto": `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`,
+	})
+
+	err := n.Notify(recordingEvent())
+	var retry *notifier.RetryError
+	if !errors.As(err, &retry) {
+		t.Fatalf("got %v, want a RetryError", err)
+	}
+	if retry.RetryAfter != 7*time.Second {
+		t.Errorf("RetryAfter = %v, want 7s", retry.RetryAfter)
+	}
+	if strings.Contains(err.Error(), "bot-token") {
+		t.Errorf("error %q leaks the bot token", err)
+	}
+	// A rate limit isn't worked around with another call
+	if len(calls) != 1 {
+		t.Fatalf("%d calls, want only sendPhoto", len(calls))
+	}
+}
+
+func TestNotifyWithoutThumbnail(t *testing.T) {
+	n, calls := serveBotAPI(t, map[string]string{
+		"sendMessage": `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`,
+	})
+
+	finalFile := filepath.Join(t.TempDir(), "recording.mp4")
+	if err := os.WriteFile(finalFile, make([]byte, 1536), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	event := notifier.Event{
+		Type:  notifier.EventDone,
+		Live:  common.ChannelLive{Title: "Stream", ChannelName: "Example Channel"},
+		Job:   &common.DownloadJob{FinalFile: finalFile},
+		Error: strings.Repeat("x", 600),
+	}
+	err := n.Notify(event)
+	var retry *notifier.RetryError
+	if err == nil || errors.As(err, &retry) {
+		t.Fatalf("got %v, want a permanent error", err)
+	}
+
+	if len(calls) != 1 {
+		t.Fatalf("%d calls, want only sendMessage", len(calls))
+	}
+	c := <-calls
+	if c.method != "sendMessage" {
+		t.Fatalf("called %s, want sendMessage", c.method)
+	}
+	if !strings.Contains(c.msg.Text, "Size: 1.5 KiB") {
+		t.Errorf("text %q is missing the file size", c.msg.Text)
+	}
+	if !strings.Contains(c.msg.Text, "<code>"+strings.Repeat("x", 500)+"…</code>") {
+		t.Errorf("text %q, want the error cut to 500 characters", c.msg.Text)
+	}
+}
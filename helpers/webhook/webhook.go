@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"streamwatcher/config"
+	"streamwatcher/helpers/notifier"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// Payload is the JSON body posted for an event when no body template is set.
+// The same fields are available to the templates.
+type Payload struct {
+	Event       string    `json:"event"`
+	ChannelID   string    `json:"channel_id"`
+	ChannelName string    `json:"channel_name"`
+	VideoID     string    `json:"video_id"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Thumbnail   string    `json:"thumbnail"`
+	Provider    string    `json:"provider"`
+	VOD         bool      `json:"vod"`
+	Status      string    `json:"status,omitempty"`
+	FinalFile   string    `json:"output_file,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Output      string    `json:"-"`
+	At          time.Time `json:"at"`
+}
+
+// request is the parsed url, method, headers and body of an event type
+type request struct {
+	url     *template.Template
+	method  *template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+}
+
+// Notifier sends events to any url. Url, method, headers and body are
+// text/template strings, the body defaults to Payload as JSON.
+type Notifier struct {
+	name     string
+	base     request
+	perEvent map[string]request
+}
+
+func init() {
+	notifier.Register("webhook", func(cfg config.NotifierConfig) (notifier.Notifier, error) {
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("webhook notifier needs a url")
+		}
+		name := cfg.Name
+		if name == "" {
+			name = "webhook"
+		}
+		n := &Notifier{name: name, perEvent: make(map[string]request)}
+
+		var err error
+		n.base, err = parseRequest(name, request{}, config.WebhookTemplate{
+			URL:     cfg.URL,
+			Method:  cfg.Method,
+			Headers: cfg.Headers,
+			Body:    cfg.Body,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for event, override := range cfg.Templates {
+			// viper lowercases map keys, so match events case-insensitively
+			n.perEvent[strings.ToLower(event)], err = parseRequest(name+"/"+event, n.base, override)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	})
+}
+
+// parseRequest parses the templates that are set, keeping the ones of base for the others
+func parseRequest(name string, base request, tmpl config.WebhookTemplate) (request, error) {
+	parse := func(field string, text string) (*template.Template, error) {
+		t, err := template.New(name + "." + field).Funcs(config.WebhookFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("[webhook] invalid %s template: %w", field, err)
+		}
+		return t, nil
+	}
+
+	req := base
+	var err error
+	if tmpl.URL != "" {
+		if req.url, err = parse("url", tmpl.URL); err != nil {
+			return req, err
+		}
+	}
+	if tmpl.Method != "" {
+		if req.method, err = parse("method", tmpl.Method); err != nil {
+			return req, err
+		}
+	}
+	if tmpl.Body != "" {
+		if req.body, err = parse("body", tmpl.Body); err != nil {
+			return req, err
+		}
+	}
+	if len(tmpl.Headers) > 0 {
+		headers := make(map[string]*template.Template)
+		for key, value := range base.headers {
+			headers[key] = value
+		}
+		for key, value := range tmpl.Headers {
+			if headers[key], err = parse("header "+key, value); err != nil {
+				return req, err
+			}
+		}
+		req.headers = headers
+	}
+	return req, nil
+}
+
+func render(t *template.Template, data Payload) (string, error) {
+	var out strings.Builder
+	if err := t.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (n *Notifier) Name() string {
+	return n.name
+}
+
+func (n *Notifier) Notify(event notifier.Event) error {
+	payload := Payload{
+		Event:       event.Type,
+		ChannelID:   event.Live.ChannelID,
+		ChannelName: event.Live.ChannelName,
+		VideoID:     event.Live.VideoID,
+		Title:       event.Live.Title,
+		URL:         event.URL,
+		Thumbnail:   event.Live.ThumbnailUrl,
+		Provider:    event.Live.Provider,
+		VOD:         event.Live.VOD,
+		Error:       event.Error,
+		At:          event.At,
+	}
+	if event.Job != nil {
+		payload.Status = event.Job.Status
+		payload.FinalFile = event.Job.FinalFile
+		payload.Output = event.Job.Output
+	}
+
+	req, exists := n.perEvent[strings.ToLower(event.Type)]
+	if !exists {
+		req = n.base
+	}
+
+	url, err := render(req.url, payload)
+	if err != nil {
+		return err
+	}
+	method := http.MethodPost
+	if req.method != nil {
+		if method, err = render(req.method, payload); err != nil {
+			return err
+		}
+		method = strings.ToUpper(strings.TrimSpace(method))
+	}
+
+	var body io.Reader
+	contentType := ""
+	if req.body != nil {
+		rendered, err := render(req.body, payload)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(rendered)
+	} else if method != http.MethodGet {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	httpReq, err := http.NewRequest(method, strings.TrimSpace(url), body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	for key, t := range req.headers {
+		value, err := render(t, payload)
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := notifier.Client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := notifier.CheckResponse(n.name, resp); err != nil {
+		return err
+	}
+	golog.Debug("[webhook] sent ", event.Type, " to ", n.name)
+	return nil
+}
@@ -0,0 +1,175 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"streamwatcher/helpers/notifier"
+	"strings"
+	"testing"
+	"time"
+)
+
+type received struct {
+	method string
+	uri    string
+	header http.Header
+	body   string
+}
+
+// serveHook records every request it gets on the returned channel
+func serveHook(t *testing.T) (string, chan received) {
+	t.Helper()
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{method: r.Method, uri: r.RequestURI, header: r.Header, body: string(body)}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, requests
+}
+
+// newNotifier creates the webhook notifier of a config file, so the keys
+// go through viper like they do at runtime
+func newNotifier(t *testing.T, toml string) notifier.Notifier {
+	t.Helper()
+	cfg, err := config.Parse([]byte("[webserver]\nport = \"3000\"\n" + toml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := notifier.New(cfg.Notifier[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func event(eventType string) notifier.Event {
+	return notifier.Event{
+		Type: eventType,
+		Live: common.ChannelLive{
+			Title:       "Karaoke",
+			ChannelID:   "UC1",
+			ChannelName: "Example Channel",
+			VideoID:     "v1",
+			Provider:    "youtube",
+		},
+		Job:   &common.DownloadJob{Status: "Finished", FinalFile: "/downloads/v1.mp4", Output: "muxing done"},
+		URL:   "https://www.youtube.com/watch?v=v1",
+		Error: "boom",
+		At:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNotifyDefaultPayload(t *testing.T) {
+	url, requests := serveHook(t)
+	n := newNotifier(t, fmt.Sprintf("[[notifier]]\ntype = \"webhook\"\nurl = \"%s/hook\"\n", url))
+	if err := n.Notify(event(notifier.EventDone)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+	if r.method != http.MethodPost || r.uri != "/hook" || r.header.Get("Content-Type") != "application/json" {
+		t.Errorf("got %s %s (%s), want a JSON POST to /hook", r.method, r.uri, r.header.Get("Content-Type"))
+	}
+	var payload Payload
+	if err := json.Unmarshal([]byte(r.body), &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := Payload{
+		Event:       "Done",
+		ChannelID:   "UC1",
+		ChannelName: "Example Channel",
+		VideoID:     "v1",
+		Title:       "Karaoke",
+		URL:         "https://www.youtube.com/watch?v=v1",
+		Provider:    "youtube",
+		Status:      "Finished",
+		FinalFile:   "/downloads/v1.mp4",
+		Error:       "boom",
+		At:          time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if payload != want {
+		t.Errorf("payload = %+v, want %+v", payload, want)
+	}
+	if strings.Contains(r.body, "muxing done") {
+		t.Errorf("body %s contains the downloader output", r.body)
+	}
+}
+
+func TestNotifyTemplates(t *testing.T) {
+	url, requests := serveHook(t)
+	n := newNotifier(t, fmt.Sprintf(`[[notifier]]
+type = "webhook"
+url = "%[1]s/hook/{{.VideoID}}"
+[notifier.headers]
+X-Token = "secret"
+X-Event = "{{.Event}}"
+[notifier.templates.Done]
+method = "get"
+url = "%[1]s/done?video={{.VideoID}}"
+[notifier.templates.Done.headers]
+X-Event = "finished {{.Status}}"
+[notifier.templates.Error]
+body = "{{.ChannelName}} failed: {{json .Error}}"
+`, url))
+
+	tests := []struct {
+		event       string
+		method      string
+		uri         string
+		xEvent      string
+		contentType string
+		// body is the exact body, or "{" for the default JSON payload
+		body string
+	}{
+		{event: notifier.EventRecording, method: "POST", uri: "/hook/v1", xEvent: "Recording", contentType: "application/json", body: "{"},
+		{event: notifier.EventDone, method: "GET", uri: "/done?video=v1", xEvent: "finished Finished"},
+		{event: notifier.EventError, method: "POST", uri: "/hook/v1", xEvent: "Error", body: `Example Channel failed: "boom"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			if err := n.Notify(event(tt.event)); err != nil {
+				t.Fatal(err)
+			}
+			r := <-requests
+			if r.method != tt.method || r.uri != tt.uri {
+				t.Errorf("got %s %s, want %s %s", r.method, r.uri, tt.method, tt.uri)
+			}
+			// Headers of an override are merged with the base ones
+			if r.header.Get("X-Token") != "secret" || r.header.Get("X-Event") != tt.xEvent {
+				t.Errorf("headers = %v, want X-Token secret and X-Event %q", r.header, tt.xEvent)
+			}
+			if r.header.Get("Content-Type") != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", r.header.Get("Content-Type"), tt.contentType)
+			}
+			if tt.body == "{" {
+				if !json.Valid([]byte(r.body)) || !strings.HasPrefix(r.body, "{") {
+					t.Errorf("body = %q, want the JSON payload", r.body)
+				}
+			} else if r.body != tt.body {
+				t.Errorf("body = %q, want %q", r.body, tt.body)
+			}
+		})
+	}
+}
+
+func TestNotifyServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	n := newNotifier(t, fmt.Sprintf("[[notifier]]\ntype = \"webhook\"\nurl = \"%s\"\n", server.URL))
+
+	var retry *notifier.RetryError
+	err := n.Notify(event(notifier.EventDone))
+	if !errors.As(err, &retry) || retry.RetryAfter != 3*time.Second {
+		t.Fatalf("got %v, want a RetryError after 3s", err)
+	}
+}
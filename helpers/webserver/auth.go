@@ -0,0 +1,201 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"streamwatcher/config"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+
+	sessionCookie = "streamwatcher_session"
+)
+
+// identity is who made a request
+type identity struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type session struct {
+	username string
+	expires  time.Time
+}
+
+var (
+	sessions     = make(map[string]session)
+	sessionsLock sync.Mutex
+)
+
+// exempt paths verify their callers themselves, or are needed before login
+var exempt = map[string]bool{
+	"/api/login":           true,
+	"/api/logout":          true,
+	"/api/websub/youtube":  true,
+	"/api/eventsub/twitch": true,
+}
+
+// adminReads are read endpoints that expose secrets
+var adminReads = map[string]bool{
+	"/api/config/toml": true,
+}
+
+func authEnabled() bool {
+	return len(config.Get().Auth.Users) > 0 || len(config.Get().Auth.Tokens) > 0
+}
+
+func role(value string) string {
+	if value == RoleAdmin {
+		return RoleAdmin
+	}
+	return RoleViewer
+}
+
+// requireAuth lets requests through by role: viewers may read the api,
+// everything that changes something or reveals secrets needs an admin.
+// The frontend itself is public so it can show the login form.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authEnabled() || !strings.HasPrefix(r.URL.Path, "/api/") || exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		who := authenticate(r)
+		if who == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="streamwatcher"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if who.Role != RoleAdmin && (!readOnly || adminReads[r.URL.Path]) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate checks the bearer token, then the session cookie
+func authenticate(r *http.Request) *identity {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		for _, t := range config.Get().Auth.Tokens {
+			if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+				return &identity{Name: t.Name, Role: role(t.Role)}
+			}
+		}
+		return nil
+	}
+
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	sessionsLock.Lock()
+	s, exists := sessions[cookie.Value]
+	if exists && time.Now().After(s.expires) {
+		delete(sessions, cookie.Value)
+		exists = false
+	}
+	sessionsLock.Unlock()
+	if !exists {
+		return nil
+	}
+	// Look the user up again, so removing it from the config ends its sessions
+	for _, user := range config.Get().Auth.Users {
+		if user.Username == s.username {
+			return &identity{Name: user.Username, Role: role(user.Role)}
+		}
+	}
+	return nil
+}
+
+func login(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var who *identity
+	for _, user := range config.Get().Auth.Users {
+		if user.Username == credentials.Username && bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(credentials.Password)) == nil {
+			who = &identity{Name: user.Username, Role: role(user.Role)}
+			break
+		}
+	}
+	if who == nil {
+		golog.Warn("[webserver] failed login for ", credentials.Username, " from ", r.RemoteAddr)
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(id)
+	hours := config.Get().Auth.SessionHours
+	if hours <= 0 {
+		hours = 24 * 7
+	}
+	expires := time.Now().Add(time.Duration(hours) * time.Hour)
+
+	sessionsLock.Lock()
+	sessions[token] = session{username: who.Name, expires: expires}
+	sessionsLock.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	golog.Info("[webserver] ", who.Name, " logged in")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(who)
+}
+
+func logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		sessionsLock.Lock()
+		delete(sessions, cookie.Value)
+		sessionsLock.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// me returns who is logged in, an admin when auth is disabled
+func me(w http.ResponseWriter, r *http.Request) {
+	who := &identity{Name: "anonymous", Role: RoleAdmin}
+	if authEnabled() {
+		who = authenticate(r)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(who)
+}
@@ -0,0 +1,226 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"streamwatcher/config"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setAuthConfig uses an admin and a viewer with the password "password",
+// a token for each role and sessions of 2 hours
+func setAuthConfig(t *testing.T) *config.Config {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Auth: config.AuthConfig{
+		Users: []config.AuthUser{
+			{Username: "alice", PasswordHash: string(hash), Role: RoleAdmin},
+			{Username: "bob", PasswordHash: string(hash)},
+		},
+		Tokens: []config.AuthToken{
+			{Name: "ci", Token: "admin-token", Role: RoleAdmin},
+			{Name: "grafana", Token: "viewer-token"},
+		},
+		SessionHours: 2,
+	}}
+
+	previous := config.Get()
+	config.Set(cfg)
+	sessionsLock.Lock()
+	sessions = make(map[string]session)
+	sessionsLock.Unlock()
+	t.Cleanup(func() { config.Set(previous) })
+	return cfg
+}
+
+// authHandler is requireAuth in front of the auth endpoints, answering 200
+// for everything else
+func authHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/login", login)
+	mux.HandleFunc("POST /api/logout", logout)
+	mux.HandleFunc("GET /api/me", me)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	return requireAuth(mux)
+}
+
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	authHandler().ServeHTTP(w, r)
+	return w
+}
+
+// loginAs returns the session cookie of a user
+func loginAs(t *testing.T, username string) *http.Cookie {
+	t.Helper()
+	w := serve(httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"`+username+`","password":"password"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("login as %s = %d", username, w.Code)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			return cookie
+		}
+	}
+	t.Fatal("login set no session cookie")
+	return nil
+}
+
+func TestRequireAuthRoles(t *testing.T) {
+	setAuthConfig(t)
+	cookies := map[string]*http.Cookie{
+		"admin cookie":  loginAs(t, "alice"),
+		"viewer cookie": loginAs(t, "bob"),
+	}
+
+	tests := []struct {
+		who    string
+		method string
+		path   string
+		want   int
+	}{
+		{"nobody", "GET", "/api/tasks", http.StatusUnauthorized},
+		{"nobody", "GET", "/", http.StatusOK},
+		{"nobody", "POST", "/api/websub/youtube", http.StatusOK},
+		{"unknown token", "GET", "/api/tasks", http.StatusUnauthorized},
+		{"viewer token", "GET", "/api/tasks", http.StatusOK},
+		{"viewer token", "HEAD", "/api/tasks", http.StatusOK},
+		{"viewer token", "POST", "/api/task", http.StatusForbidden},
+		{"viewer token", "DELETE", "/api/task/v1", http.StatusForbidden},
+		{"viewer token", "GET", "/api/config/toml", http.StatusForbidden},
+		{"viewer cookie", "GET", "/api/tasks", http.StatusOK},
+		{"viewer cookie", "POST", "/api/config/reload", http.StatusForbidden},
+		{"viewer cookie", "GET", "/api/config/toml", http.StatusForbidden},
+		{"admin token", "POST", "/api/task", http.StatusOK},
+		{"admin token", "GET", "/api/config/toml", http.StatusOK},
+		{"admin cookie", "DELETE", "/api/task/v1", http.StatusOK},
+		{"admin cookie", "GET", "/api/config/toml", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.who+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			switch tt.who {
+			case "unknown token":
+				r.Header.Set("Authorization", "Bearer wrong")
+			case "viewer token":
+				r.Header.Set("Authorization", "Bearer viewer-token")
+			case "admin token":
+				r.Header.Set("Authorization", "Bearer admin-token")
+			case "viewer cookie", "admin cookie":
+				r.AddCookie(cookies[tt.who])
+			}
+			if w := serve(r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAuthDisabled(t *testing.T) {
+	previous := config.Get()
+	config.Set(&config.Config{})
+	t.Cleanup(func() { config.Set(previous) })
+
+	if w := serve(httptest.NewRequest(http.MethodGet, "/api/config/toml", nil)); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 without users and tokens", w.Code)
+	}
+}
+
+func TestBearerTakesPrecedenceOverCookie(t *testing.T) {
+	setAuthConfig(t)
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.AddCookie(loginAs(t, "alice"))
+	r.Header.Set("Authorization", "Bearer viewer-token")
+
+	w := serve(r)
+	var who identity
+	if err := json.NewDecoder(w.Body).Decode(&who); err != nil {
+		t.Fatal(err)
+	}
+	if who != (identity{Name: "grafana", Role: RoleViewer}) {
+		t.Errorf("me = %+v, want the viewer token", who)
+	}
+
+	// A wrong token doesn't fall back to the cookie
+	r.Header.Set("Authorization", "Bearer wrong")
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	setAuthConfig(t)
+
+	w := serve(httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"username":"alice","password":"wrong"}`)))
+	if w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Errorf("wrong password = %d with cookies %v, want 401 without", w.Code, w.Result().Cookies())
+	}
+
+	cookie := loginAs(t, "alice")
+	if !cookie.HttpOnly || cookie.Path != "/" {
+		t.Errorf("cookie = %+v, want it HttpOnly for /", cookie)
+	}
+	if until := time.Until(cookie.Expires); until < time.Hour || until > 2*time.Hour {
+		t.Errorf("cookie expires in %v, want 2h", until)
+	}
+}
+
+func TestSessionExpires(t *testing.T) {
+	setAuthConfig(t)
+	cookie := loginAs(t, "alice")
+	sessionsLock.Lock()
+	sessions[cookie.Value] = session{username: "alice", expires: time.Now().Add(-time.Second)}
+	sessionsLock.Unlock()
+
+	r := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	r.AddCookie(cookie)
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401 for an expired session", w.Code)
+	}
+	sessionsLock.Lock()
+	_, exists := sessions[cookie.Value]
+	sessionsLock.Unlock()
+	if exists {
+		t.Error("expired session was kept")
+	}
+}
+
+func TestLogoutEndsSession(t *testing.T) {
+	setAuthConfig(t)
+	cookie := loginAs(t, "alice")
+
+	logoutRequest := httptest.NewRequest(http.MethodPost, "/api/logout", nil)
+	logoutRequest.AddCookie(cookie)
+	if w := serve(logoutRequest); w.Code != http.StatusNoContent {
+		t.Fatalf("logout = %d, want 204", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	r.AddCookie(cookie)
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401 after logout", w.Code)
+	}
+}
+
+func TestRemovedUserLosesSessions(t *testing.T) {
+	cfg := setAuthConfig(t)
+	cookie := loginAs(t, "bob")
+
+	removed := *cfg
+	removed.Auth.Users = cfg.Auth.Users[:1]
+	config.Set(&removed)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/tasks", nil)
+	r.AddCookie(cookie)
+	if w := serve(r); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401 once the user was removed", w.Code)
+	}
+}
@@ -1,17 +1,45 @@
-import { Tabs } from '@mantine/core';
+import { Button, Group, Tabs, Text } from '@mantine/core';
 import React, { Suspense } from 'react';
+import { useMutateLogout, useQueryMe } from './api/auth';
 import { SuspenseLoader } from './shared/SuspenseLoader';
 
 const TasksPage = React.lazy(() => import('./pages/TasksPage'));
 const ConfigPage = React.lazy(() => import('./pages/ConfigPage'));
+const LoginPage = React.lazy(() => import('./pages/LoginPage'));
 
 function App() {
+  const qMe = useQueryMe();
+  const mLogout = useMutateLogout();
+
+  if (qMe.isLoading) return <SuspenseLoader />;
+  if (qMe.isError)
+    return (
+      <Suspense fallback={<SuspenseLoader />}>
+        <LoginPage />
+      </Suspense>
+    );
+
+  const isAdmin = qMe.data?.role === 'admin';
   return (
     <>
       <Tabs defaultValue="tasks">
         <Tabs.List>
           <Tabs.Tab value="tasks">Tasks</Tabs.Tab>
-          <Tabs.Tab value="config">Configuration</Tabs.Tab>
+          {isAdmin && <Tabs.Tab value="config">Configuration</Tabs.Tab>}
+          {qMe.data?.name !== 'anonymous' && (
+            <Group ml="auto" spacing="xs">
+              <Text size="sm" color="dimmed">
+                {qMe.data?.name} ({qMe.data?.role})
+              </Text>
+              <Button
+                variant="subtle"
+                size="xs"
+                onClick={() => mLogout.mutate()}
+              >
+                Log out
+              </Button>
+            </Group>
+          )}
         </Tabs.List>
 
         <Tabs.Panel value="tasks">
@@ -19,11 +47,13 @@ function App() {
             <TasksPage />
           </Suspense>
         </Tabs.Panel>
-        <Tabs.Panel value="config">
-          <Suspense fallback={<SuspenseLoader />}>
-            <ConfigPage />
-          </Suspense>
-        </Tabs.Panel>
+        {isAdmin && (
+          <Tabs.Panel value="config">
+            <Suspense fallback={<SuspenseLoader />}>
+              <ConfigPage />
+            </Suspense>
+          </Tabs.Panel>
+        )}
       </Tabs>
     </>
   );
@@ -0,0 +1,50 @@
+import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
+import { rejectError } from './api';
+
+export interface Me {
+  name: string;
+  role: 'admin' | 'viewer';
+}
+
+export const useQueryMe = () =>
+  useQuery(
+    ['me'],
+    () =>
+      fetch('/api/me')
+        .then(rejectError)
+        .then((res) => res.json())
+        .then((res) => res as Me),
+    { retry: false }
+  );
+
+export const useMutateLogin = () => {
+  const queryClient = useQueryClient();
+  return useMutation(
+    (credentials: { username: string; password: string }) =>
+      fetch('/api/login', {
+        method: 'POST',
+        body: JSON.stringify(credentials),
+        headers: { 'Content-Type': 'application/json' },
+      })
+        .then(rejectError)
+        .then((res) => res.json())
+        .then((res) => res as Me),
+    {
+      onSuccess: () => {
+        queryClient.invalidateQueries();
+      },
+    }
+  );
+};
+
+export const useMutateLogout = () => {
+  const queryClient = useQueryClient();
+  return useMutation(
+    () => fetch('/api/logout', { method: 'POST' }).then(rejectError),
+    {
+      onSuccess: () => {
+        queryClient.invalidateQueries();
+      },
+    }
+  );
+};
@@ -2,6 +2,19 @@ import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
 import { Config } from '../bindings/Config';
 import { rejectError } from './api';
 
+// What a reload or an update of the configuration changed
+export interface ConfigDiff {
+  added?: string[];
+  removed?: string[];
+  changed?: string[];
+  restart?: string[];
+}
+
+// The body of a 422 response to PUT /api/config/toml
+export interface ConfigValidationError {
+  errors: { field: string; message: string }[];
+}
+
 export const useQueryConfig = () =>
   useQuery(['config'], () =>
     fetch('/api/config')
@@ -16,7 +29,8 @@ export const useMutateReloadConfig = () => {
     () =>
       fetch('/api/config/reload', { method: 'POST' })
         .then(rejectError)
-        .then((res) => res.json()),
+        .then((res) => res.json())
+        .then((res) => res as ConfigDiff),
     {
       onSuccess: () => {
         queryClient.invalidateQueries(['config']);
@@ -43,7 +57,8 @@ export const useMutateConfigTOML = () => {
         headers: { 'Content-Type': 'text/toml' },
       })
         .then(rejectError)
-        .then((res) => res.text()),
+        .then((res) => res.json())
+        .then((res) => res as ConfigDiff),
     {
       onSuccess: () => {
         queryClient.invalidateQueries(['config']);
@@ -1,3 +1,4 @@
+import React from 'react';
 import { useMutation, useQuery, useQueryClient } from '@tanstack/react-query';
 import { YTAState } from '../bindings/YTAState';
 import { TaskWithStatus } from '../bindings/TaskWithStatus';
@@ -25,8 +26,34 @@ const stateSort: ReturnType<typeof stateKey>[] = [
   'AlreadyProcessed',
   'Interrupted',
 ];
-export const useQueryTasks = () =>
-  useQuery(
+// Keeps the tasks query up to date from /api/events, polling is only a fallback
+const useTaskEvents = () => {
+  const queryClient = useQueryClient();
+  React.useEffect(() => {
+    const source = new EventSource('/api/events');
+    const update = (e: MessageEvent) => {
+      const changed = JSON.parse(e.data) as TaskWithStatus;
+      queryClient.setQueryData<TaskWithStatus[]>(['tasks'], (tasks) =>
+        tasks?.map((t) =>
+          t.task.video_id === changed.task.video_id ? changed : t
+        )
+      );
+    };
+    const refetch = () => queryClient.invalidateQueries(['tasks']);
+    source.addEventListener('state', update);
+    source.addEventListener('progress', update);
+    source.addEventListener('updated', update);
+    source.addEventListener('finished', update);
+    source.addEventListener('created', refetch);
+    source.addEventListener('deleted', refetch);
+    source.onopen = refetch;
+    return () => source.close();
+  }, [queryClient]);
+};
+
+export const useQueryTasks = () => {
+  useTaskEvents();
+  return useQuery(
     ['tasks'],
     () =>
       fetch('/api/tasks')
@@ -39,10 +66,11 @@ export const useQueryTasks = () =>
           )
         ),
     {
-      refetchInterval: 1000,
+      refetchInterval: 30000,
       keepPreviousData: true,
     }
   );
+};
 
 export const useMutateCreateTask = () => {
   const queryClient = useQueryClient();
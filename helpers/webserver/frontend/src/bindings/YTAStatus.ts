@@ -1,4 +1,4 @@
 // This file was generated by [ts-rs](https://github.com/Aleph-Alpha/ts-rs). Do not edit this file manually.
 import type { YTAState } from "./YTAState";
 
-export interface YTAStatus { version: string | null, state: YTAState, last_output: string | null, last_update: string, video_fragments: number | null, audio_fragments: number | null, total_size: string | null, video_quality: string | null, output_file: string | null, }
\ No newline at end of file
+export interface YTAStatus { version: string | null, state: YTAState, last_output: string | null, last_update: string, video_fragments: number | null, audio_fragments: number | null, total_size: string | null, video_quality: string | null, output_file: string | null, attempts: number, last_error: string | null, }
\ No newline at end of file
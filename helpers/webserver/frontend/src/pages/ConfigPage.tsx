@@ -9,11 +9,44 @@ import {
 } from '@mantine/core';
 import { showNotification } from '@mantine/notifications';
 import {
+  ConfigDiff,
+  ConfigValidationError,
   useMutateConfigTOML,
   useMutateReloadConfig,
   useQueryConfigTOML,
 } from '../api/config';
 
+const describeDiff = (diff: ConfigDiff) => {
+  const lines = [
+    ...(diff.added ?? []).map((c) => `Added ${c}`),
+    ...(diff.removed ?? []).map((c) => `Removed ${c}`),
+    ...(diff.changed ?? []).map((c) => `Changed ${c}`),
+    ...(diff.restart ?? []).map((c) => `Restart to apply ${c}`),
+  ];
+  return lines.length ? lines.join('\n') : 'Nothing changed';
+};
+
+const showConfigError = async (title: string, err: unknown) => {
+  let message = '';
+  if (err instanceof Response)
+    message =
+      err.status === 422 &&
+      err.headers.get('Content-Type') === 'application/json'
+        ? ((await err.json()) as ConfigValidationError).errors
+            .map((e) => (e.field ? `${e.field}: ${e.message}` : e.message))
+            .join('\n')
+        : await err.text();
+  showNotification({
+    title,
+    message,
+    color: 'red',
+    styles: (_) => ({
+      description: { whiteSpace: 'pre' },
+    }),
+  });
+  console.error(err);
+};
+
 const ConfigPage = () => {
   const qConfig = useQueryConfigTOML();
   const mReload = useMutateReloadConfig();
@@ -31,35 +64,32 @@ const ConfigPage = () => {
     if (!isEditable)
       // Reload configuration
       mReload.mutate(undefined, {
-        onSuccess() {
+        onSuccess(diff) {
           showNotification({
-            message: 'Configuration reloaded',
+            title: 'Configuration reloaded',
+            message: describeDiff(diff),
             color: 'green',
+            styles: (_) => ({
+              description: { whiteSpace: 'pre' },
+            }),
           });
         },
+        onError: (err) => showConfigError('Error reloading configuration', err),
       });
     // Update configuration with the textarea content
     else
       mUpdate.mutate(textContent, {
-        onSuccess() {
+        onSuccess(diff) {
           showNotification({
-            message: 'Configuration updated',
+            title: 'Configuration updated',
+            message: describeDiff(diff),
             color: 'green',
-          });
-        },
-        async onError(err) {
-          let message = '';
-          if (err instanceof Response) message = await err.text();
-          showNotification({
-            title: 'Error updating configuration',
-            message,
-            color: 'red',
             styles: (_) => ({
               description: { whiteSpace: 'pre' },
             }),
           });
-          console.error(err);
         },
+        onError: (err) => showConfigError('Error updating configuration', err),
       });
   };
 
@@ -0,0 +1,54 @@
+import React from 'react';
+import {
+  Button,
+  Container,
+  PasswordInput,
+  Stack,
+  Text,
+  TextInput,
+} from '@mantine/core';
+import { useMutateLogin } from '../api/auth';
+
+const LoginPage = () => {
+  const mLogin = useMutateLogin();
+  const [username, setUsername] = React.useState('');
+  const [password, setPassword] = React.useState('');
+
+  const submit = (e: React.FormEvent) => {
+    e.preventDefault();
+    mLogin.mutate({ username, password });
+  };
+
+  return (
+    <Container size="xs" mt="xl">
+      <form onSubmit={submit}>
+        <Stack>
+          <TextInput
+            label="Username"
+            value={username}
+            onChange={(e) => setUsername(e.currentTarget.value)}
+            autoComplete="username"
+            required
+          />
+          <PasswordInput
+            label="Password"
+            value={password}
+            onChange={(e) => setPassword(e.currentTarget.value)}
+            autoComplete="current-password"
+            required
+          />
+          {mLogin.isError && (
+            <Text color="red" size="sm">
+              Invalid username or password
+            </Text>
+          )}
+          <Button type="submit" loading={mLogin.isLoading}>
+            Log in
+          </Button>
+        </Stack>
+      </form>
+    </Container>
+  );
+};
+
+export default LoginPage;
@@ -58,6 +58,11 @@ const rowElements = ({ task, status }: TaskWithStatus) => [
     >
       {task.title}
     </Anchor>
+    {task.vod && (
+      <Badge size="xs" variant="outline" title={'VOD of stream ' + task.parent_id}>
+        VOD
+      </Badge>
+    )}
     <Anchor
       color="dimmed"
       style={{ display: 'block' }}
@@ -66,7 +71,14 @@ const rowElements = ({ task, status }: TaskWithStatus) => [
       {task.channel_name}
     </Anchor>
   </>,
-  <TaskStateBadge state={status.state} />,
+  <>
+    <TaskStateBadge state={status.state} />
+    {status.attempts > 1 && (
+      <Text size="xs" color="dimmed" title={status.last_error || undefined}>
+        Attempt {status.attempts}
+      </Text>
+    )}
+  </>,
   <>
     {status.total_size === null ? (
       'None'
@@ -13,6 +13,8 @@ type Task struct {
 	ChannelID       string `json:"channel_id"`
 	ChannelPicture  string `json:"channel_picture"`
 	OutputDirectory string `json:"output_directory"`
+	VOD             bool   `json:"vod"`
+	ParentID        string `json:"parent_id"`
 }
 
 type Status struct {
@@ -25,6 +27,8 @@ type Status struct {
 	TotalSize      any    `json:"total_size"`
 	VideoQuality   any    `json:"video_quality"`
 	OutputFile     any    `json:"output_file"`
+	Attempts       int    `json:"attempts"`
+	LastError      string `json:"last_error"`
 }
 
 type Response struct {
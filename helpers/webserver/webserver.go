@@ -1,8 +1,10 @@
 package webserver
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -13,11 +15,11 @@ import (
 	"sort"
 	"streamwatcher/common"
 	"streamwatcher/config"
-	"streamwatcher/helpers/ytarchive"
+	"streamwatcher/helpers/downloader"
+	"streamwatcher/provider"
 	"streamwatcher/provider/twitch"
 	"streamwatcher/provider/youtube"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/kataras/golog"
@@ -40,12 +42,28 @@ func (fs spaFileSystem) Open(path string) (http.File, error) {
 	return f, err
 }
 
-func StartServer() {
+var appContext = context.Background()
+
+// StartServer serves the api and the frontend until ctx is done. Tasks added
+// through the api are tied to ctx.
+func StartServer(ctx context.Context) {
+	appContext = ctx
+
 	// API routes
 	http.HandleFunc("/api/tasks", getDownloadJobs)
+	http.HandleFunc("GET /api/events", streamEvents)
 	http.HandleFunc("/api/task", addTask)
+	http.HandleFunc("POST /api/task/{id}/stop", stopTask)
+	http.HandleFunc("DELETE /api/task/{id}", deleteTask)
+	http.HandleFunc("GET /api/channels/status", getChannelsStatus)
 	http.HandleFunc("/api/config/toml", tomlConfig)
+	http.HandleFunc("POST /api/config/reload", reloadConfig)
 	http.HandleFunc("/api/config", getConfig)
+	http.HandleFunc("/api/websub/youtube", youtube.WebSubHandler)
+	http.HandleFunc("/api/eventsub/twitch", twitch.EventSubHandler)
+	http.HandleFunc("POST /api/login", login)
+	http.HandleFunc("POST /api/logout", logout)
+	http.HandleFunc("GET /api/me", me)
 
 	// Static files handling
 	staticFS, err := fs.Sub(staticFiles, "frontend/dist")
@@ -59,7 +77,22 @@ func StartServer() {
 	}
 
 	http.Handle("/", http.FileServer(spa))
-	golog.Info(http.ListenAndServe(fmt.Sprintf("%s:%s", config.AppConfig.Webserver.Host, config.AppConfig.Webserver.Port), nil))
+	if !authEnabled() {
+		golog.Warn("[webserver] no [auth] users or tokens configured, the web UI and api are open to anyone who can reach them")
+	}
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%s", config.Get().Webserver.Host, config.Get().Webserver.Port),
+		Handler: requireAuth(http.DefaultServeMux),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		golog.Info(err)
+	}
 }
 func addTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -79,60 +112,66 @@ func addTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if parsedUrl.Host == "twitch.tv" || parsedUrl.Host == "www.twitch.tv" {
-		twitchUsername := strings.TrimPrefix(parsedUrl.Path, "/")
-		channelLive, err := twitch.GetChannelInfo(twitchUsername)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if channelLive == nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusCreated)
-			response := map[string]interface{}{
-				"message": "Task not added",
-				"status":  "Channel maybe offline",
-			}
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-		go func() {
-			twitch.TwitchStartDownload(twitchUsername, channelLive, task.OutPath)
-		}()
+	p, channelLive, err := provider.FromURL(parsedUrl)
+	if errors.Is(err, provider.ErrUnsupportedURL) {
+		http.Error(w, "Unsupported URL", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if channelLive == nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		response := map[string]interface{}{
-			"message": "Task added successfully",
-			"status":  channelLive,
+			"message": "Task not added",
+			"status":  "Channel maybe offline",
 		}
 		json.NewEncoder(w).Encode(response)
-	} else {
-		url := youtube.ParseVideoID(parsedUrl)
-		if url == nil {
-			http.Error(w, "Invalid YouTube URL", http.StatusBadRequest)
-			return
-		} else {
-			channelLive, err := youtube.GetVideoDetailsFromID(*url)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			go func() {
-				golog.Info("[webserver] Added task for video from api: ", channelLive.VideoID)
-				ytarchive.StartDownload("https://www.youtube.com/watch?v="+channelLive.VideoID, []string{}, channelLive, task.OutPath)
-			}()
-
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusCreated)
-			response := map[string]interface{}{
-				"message": "Task added successfully",
-				"status":  channelLive,
-			}
-			json.NewEncoder(w).Encode(response)
-		}
+		return
 	}
+	go func() {
+		golog.Info("[webserver] Added task for video from api: ", channelLive.VideoID)
+		channel := provider.ChannelFor(p, channelLive.ChannelID)
+		channel.OutPath = task.OutPath
+		p.StartDownload(appContext, channelLive, channel)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	response := map[string]interface{}{
+		"message": "Task added successfully",
+		"status":  channelLive,
+	}
+	json.NewEncoder(w).Encode(response)
+}
+func stopTask(w http.ResponseWriter, r *http.Request) {
+	err := downloader.Stop(r.PathValue("id"))
+	writeTaskControlResponse(w, err, "Task stopped")
+}
 
+func deleteTask(w http.ResponseWriter, r *http.Request) {
+	err := downloader.Delete(r.PathValue("id"))
+	writeTaskControlResponse(w, err, "Task deleted")
 }
+
+func writeTaskControlResponse(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, downloader.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, downloader.ErrJobNotRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": message,
+		})
+	}
+}
+
 func getDownloadJobs(w http.ResponseWriter, r *http.Request) {
 	common.DownloadJobsLock.Lock()
 	defer common.DownloadJobsLock.Unlock()
@@ -148,47 +187,10 @@ func getDownloadJobs(w http.ResponseWriter, r *http.Request) {
 
 func convertDownloadJobsToResponse(jobs map[string]*common.DownloadJob) []Response {
 	var responses []Response
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
-	// Precompile regex patterns
-	// sizePattern := regexp.MustCompile(`size=\s*([\d.]+[KMG]i?B?)\s*time=.*bitrate=([\d.]+[kMG]?bits/s)\s*speed=([\d.]+x)`)
-	// fragmentsPattern := regexp.MustCompile(`Video Fragments:\s*(\d+);\s*Audio Fragments:\s*(\d+);\s*Total Downloaded:\s*([\d.]+[KMG]i?B?)`)
-
 	for _, job := range jobs {
-		wg.Add(1)
-		go func(job *common.DownloadJob) {
-			defer wg.Done()
-			response := Response{
-				Task: Task{
-					Title:           job.ChannelLive.Title,
-					VideoID:         job.VideoID,
-					VideoPicture:    job.ChannelLive.ThumbnailUrl,
-					ChannelName:     job.ChannelLive.ChannelName,
-					ChannelID:       job.ChannelLive.ChannelID,
-					ChannelPicture:  job.ChannelLive.ChannelPicture,
-					OutputDirectory: job.OutPath,
-				},
-				Status: Status{
-					Version:        "",
-					State:          job.Status,
-					LastOutput:     job.Output,
-					LastUpdate:     job.ChannelLive.DateCrawled,
-					VideoFragments: job.VideoFragments,
-					AudioFragments: job.AudioFragments,
-					TotalSize:      job.TotalSize,
-					VideoQuality:   nil,
-					OutputFile:     job.FinalFile,
-				},
-			}
-			mu.Lock()
-			responses = append(responses, response)
-			mu.Unlock()
-		}(job)
+		responses = append(responses, jobResponse(job))
 	}
 
-	wg.Wait()
-
 	sort.Slice(responses, func(i, j int) bool {
 		timeI, _ := time.Parse(time.RFC3339, responses[i].Status.LastUpdate)
 		timeJ, _ := time.Parse(time.RFC3339, responses[j].Status.LastUpdate)
@@ -198,6 +200,75 @@ func convertDownloadJobsToResponse(jobs map[string]*common.DownloadJob) []Respon
 	return responses
 }
 
+func jobResponse(job *common.DownloadJob) Response {
+	return Response{
+		Task: Task{
+			Title:           job.ChannelLive.Title,
+			VideoID:         job.VideoID,
+			VideoPicture:    job.ChannelLive.ThumbnailUrl,
+			ChannelName:     job.ChannelLive.ChannelName,
+			ChannelID:       job.ChannelLive.ChannelID,
+			ChannelPicture:  job.ChannelLive.ChannelPicture,
+			OutputDirectory: job.OutPath,
+			VOD:             job.ChannelLive.VOD,
+			ParentID:        job.ChannelLive.ParentID,
+		},
+		Status: Status{
+			Version:        "",
+			State:          job.Status,
+			LastOutput:     job.Output,
+			LastUpdate:     job.ChannelLive.DateCrawled,
+			VideoFragments: job.VideoFragments,
+			AudioFragments: job.AudioFragments,
+			TotalSize:      job.TotalSize,
+			VideoQuality:   nil,
+			OutputFile:     job.FinalFile,
+			Attempts:       job.Attempts,
+			LastError:      job.LastError,
+		},
+	}
+}
+
+// streamEvents pushes every job change as a Server-Sent Event, the data is
+// the job in the format of /api/tasks
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := common.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-appContext.Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			data, err := json.Marshal(jobResponse(event.Job))
+			if err != nil {
+				golog.Warn("[webserver] failed to encode event: ", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
 func parseOutput(output string, sizePattern, fragmentsPattern *regexp.Regexp) (map[string]string, error) {
 	if strings.HasPrefix(output, "size=") {
 		matches := sizePattern.FindStringSubmatch(output)
@@ -226,11 +297,18 @@ func parseOutput(output string, sizePattern, fragmentsPattern *regexp.Regexp) (m
 	return nil, fmt.Errorf("output does not start with expected pattern")
 }
 
+func getChannelsStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"twitch": twitch.ChannelStates(),
+	})
+}
+
 func getConfig(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var response ConfigResponse
 
-	for _, channel := range config.AppConfig.YouTubeChannel {
+	for _, channel := range config.Get().YouTubeChannel {
 		response.Channel = append(response.Channel, Channel{
 			ID:               channel.ID,
 			Name:             channel.Name,
@@ -245,11 +323,9 @@ func getConfig(w http.ResponseWriter, r *http.Request) {
 }
 
 func tomlConfig(w http.ResponseWriter, r *http.Request) {
-	filePath := "./config.toml"
-
 	switch r.Method {
 	case http.MethodGet:
-		content, err := os.ReadFile(filePath)
+		content, err := os.ReadFile(config.File)
 		if err != nil {
 			http.Error(w, "Unable to read config file", http.StatusInternalServerError)
 			golog.Warn("Error reading config file:", err)
@@ -265,16 +341,42 @@ func tomlConfig(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = os.WriteFile(filePath, content, 0644)
-		if err != nil {
+		if _, err := config.Parse(content); err != nil {
+			writeConfigError(w, err)
+			return
+		}
+
+		if err := config.Save(config.File, content); err != nil {
 			http.Error(w, "Unable to write to config file", http.StatusInternalServerError)
 			golog.Warn("Error writing to config file:", err)
 			return
 		}
-
-		w.WriteHeader(http.StatusNoContent)
+		reloadConfig(w, r)
 
 	default:
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 	}
 }
+
+// reloadConfig applies config.toml and returns what changed
+func reloadConfig(w http.ResponseWriter, r *http.Request) {
+	diff, err := config.Reload()
+	if err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
+
+// writeConfigError responds with the field errors of an invalid config
+func writeConfigError(w http.ResponseWriter, err error) {
+	var errs config.ValidationError
+	if !errors.As(err, &errs) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]any{"errors": errs})
+}
@@ -1,72 +1,42 @@
 package ytarchive
 
 import (
-	"os/exec"
 	"path"
 	"regexp"
-	"runtime"
 	"streamwatcher/common"
 	"streamwatcher/config"
-	"streamwatcher/helpers/discord"
+	"streamwatcher/helpers/downloader"
 	"strings"
-	"sync"
 
 	"github.com/kataras/golog"
 )
 
-func StartDownload(url string, args []string, channelLive *common.ChannelLive, outPath string) {
-	var cmd *exec.Cmd
-	var allArgs []string
-	allArgs = append(allArgs, args...)
-	allArgs = append(allArgs, config.AppConfig.YTArchive.Args...)
-	allArgs = append(allArgs, url)
-	allArgs = append(allArgs, config.AppConfig.YTArchive.Quality)
+// Downloader records YouTube streams with ytarchive
+type Downloader struct{}
 
-	if runtime.GOOS == "windows" {
-		cmdArgs := append([]string{"/C", config.AppConfig.YTArchive.ExecutablePath}, allArgs...)
-		cmd = exec.Command("cmd", cmdArgs...)
-		cmd.Dir = config.AppConfig.YTArchive.WorkingDirectory
-	} else {
-		cmd = exec.Command(config.AppConfig.YTArchive.ExecutablePath, allArgs...)
-		cmd.Dir = config.AppConfig.YTArchive.WorkingDirectory
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		golog.Debug("[ytarchive] Error creating StdoutPipe:", err)
-		return
-	}
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		golog.Debug("[ytarchive] Error creating StderrPipe:", err)
-		return
-	}
-
-	common.AddDownloadJob(channelLive.VideoID, *channelLive, "Idle", "", outPath)
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		golog.Warn("[ytarchive] Failed to start command: ", err)
-		return
-	}
-	outputBuffer := make([]byte, 4096)
-
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go common.ReadStdout(stdout, outputBuffer, parseOutput, channelLive.VideoID, &wg, "ytarchive")
+func init() {
+	downloader.Register(Downloader{})
+}
 
-	// Read stderr (in case progress is written to stderr)
-	go common.ReadStderr(stderr, outputBuffer, parseOutput, channelLive.VideoID, &wg, "ytarchive")
+func (Downloader) Name() string {
+	return "ytarchive"
+}
 
-	wg.Wait()
+func (Downloader) Command() (string, string) {
+	return config.Get().YTArchive.ExecutablePath, config.Get().YTArchive.WorkingDirectory
+}
 
-	if err := cmd.Wait(); err != nil {
-		golog.Warn("[ytarchive] Error waiting for command to finish:", err)
-	}
+func (Downloader) BuildArgs(url string, args []string) []string {
+	var allArgs []string
+	allArgs = append(allArgs, args...)
+	allArgs = append(allArgs, config.Get().YTArchive.Args...)
+	allArgs = append(allArgs, url)
+	allArgs = append(allArgs, config.Get().YTArchive.Quality)
+	return allArgs
+}
 
-	golog.Debug("[ytarchive] Exited")
+func (Downloader) ParseOutput(output string, videoID string) {
+	parseOutput(output, videoID)
 }
 
 func parseOutput(output string, videoId string) {
@@ -74,7 +44,7 @@ func parseOutput(output string, videoId string) {
 	common.DownloadJobsLock.Lock()
 	defer common.DownloadJobsLock.Unlock()
 	if strings.Contains(output, "Video Fragments") {
-		common.DownloadJobs[videoId].Status = "Downloading"
+		common.DownloadJobs[videoId].SetStatus("Downloading")
 		parts := strings.Split(output, ";")
 		videoFragments := strings.TrimSpace(strings.Split(parts[0], ":")[1])
 		audioFragments := strings.TrimSpace(strings.Split(parts[1], ":")[1])
@@ -91,11 +61,11 @@ func parseOutput(output string, videoId string) {
 		}
 	} else if strings.Contains(output, "Waiting for stream") {
 		common.DownloadJobs[videoId].Output = output
-		common.DownloadJobs[videoId].Status = "Waiting"
+		common.DownloadJobs[videoId].SetStatus("Waiting")
 	} else if strings.Contains(output, "Muxing final file") {
-		common.DownloadJobs[videoId].Status = "Muxing"
+		common.DownloadJobs[videoId].SetStatus("Muxing")
 	} else if strings.Contains(output, "Livestream has been processed") {
-		common.DownloadJobs[videoId].Status = "Processed"
+		common.DownloadJobs[videoId].SetStatus("Processed")
 	} else if strings.Contains(output, "Final audio") {
 		filePath := strings.Split(output, "Final audio file: ")[1]
 		filename := path.Base(filePath)
@@ -103,7 +73,7 @@ func parseOutput(output string, videoId string) {
 			golog.Warn("[ytarchive] Failed to move audio file: ", err)
 		}
 	} else if strings.Contains(output, "Final file") {
-		common.DownloadJobs[videoId].Status = "Finished"
+		common.DownloadJobs[videoId].SetStatus("Finished")
 		common.DownloadJobs[videoId].Output = output
 		filePath := strings.Split(output, "Final file: ")[1]
 		filename := path.Base(filePath)
@@ -111,10 +81,9 @@ func parseOutput(output string, videoId string) {
 			golog.Warn("[ytarchive] Failed to move file: ", err)
 		}
 		common.DownloadJobs[videoId].FinalFile = common.DownloadJobs[videoId].OutPath + "/" + filename
-		discord.SendNotificationWebhook(common.DownloadJobs[videoId].ChannelLive.ChannelName, common.DownloadJobs[videoId].ChannelLive.Title, "https://www.youtube.com/watch?v="+common.DownloadJobs[videoId].VideoID, common.DownloadJobs[videoId].ChannelLive.ThumbnailUrl, "Done")
+		common.SaveDownloadJob(common.DownloadJobs[videoId])
 	} else if strings.Contains(output, "Error retrieving player response") || strings.Contains(output, "unable to retrieve") || strings.Contains(output, "error writing the muxcmd file") || strings.Contains(output, "Something must have gone wrong with ffmpeg") || strings.Contains(output, "At least one error occurred") || strings.Contains(output, "ERROR: ") {
-		common.DownloadJobs[videoId].Status = "Error"
+		common.DownloadJobs[videoId].SetStatus("Error")
 		common.DownloadJobs[videoId].Output = output
-		discord.SendNotificationWebhook(common.DownloadJobs[videoId].ChannelLive.ChannelName, common.DownloadJobs[videoId].ChannelLive.Title+" Error: "+output, "https://www.youtube.com/watch?v="+common.DownloadJobs[videoId].VideoID, common.DownloadJobs[videoId].ChannelLive.ThumbnailUrl, "Error")
 	}
 }
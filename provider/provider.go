@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"streamwatcher/common"
+	"streamwatcher/helpers/notifier"
+	"time"
+)
+
+var ErrUnsupportedURL = errors.New("url is not supported by this provider")
+
+// Channel is a configured channel, as seen by the scheduler
+type Channel struct {
+	ID      string
+	Name    string
+	Filters []string
+	OutPath string
+	// Downloader is the name of the backend used to record the channel
+	Downloader string
+	// Interval overrides the global checker interval when set
+	Interval time.Duration
+	// Options holds the provider specific channel config
+	Options any
+}
+
+// Provider is a site that can be watched for live streams
+type Provider interface {
+	Name() string
+	Enabled() bool
+	ListChannels() []Channel
+	// CheckLive returns the live streams of a channel, nil when it is offline
+	CheckLive(ctx context.Context, channel Channel) ([]*common.ChannelLive, error)
+	// ShouldRecord applies the channel filters to a live stream
+	ShouldRecord(live *common.ChannelLive, channel Channel) bool
+	StartDownload(ctx context.Context, live *common.ChannelLive, channel Channel)
+	WatchURL(live *common.ChannelLive) string
+	// LiveFromURL resolves a user supplied url, returning ErrUnsupportedURL
+	// when the url belongs to another site
+	LiveFromURL(u *url.URL) (*common.ChannelLive, error)
+}
+
+// BatchChecker is implemented by providers that can check many channels in
+// a single request. The result is keyed by channel ID.
+type BatchChecker interface {
+	CheckLiveBatch(ctx context.Context, channels []Channel) (map[string][]*common.ChannelLive, error)
+}
+
+var providers []Provider
+
+func Register(p Provider) {
+	providers = append(providers, p)
+}
+
+func All() []Provider {
+	return providers
+}
+
+func Get(name string) Provider {
+	for _, p := range providers {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// WatchURL returns the url of a live stream using the provider that found it
+func WatchURL(live *common.ChannelLive) string {
+	if p := Get(live.Provider); p != nil {
+		return p.WatchURL(live)
+	}
+	return ""
+}
+
+// ChannelFor returns the configured channel of a live stream, or an empty
+// channel when it isn't configured
+func ChannelFor(p Provider, channelID string) Channel {
+	for _, channel := range p.ListChannels() {
+		if channel.ID == channelID {
+			return channel
+		}
+	}
+	return Channel{ID: channelID}
+}
+
+// FromURL finds the provider that supports the url and resolves the live stream
+func FromURL(u *url.URL) (Provider, *common.ChannelLive, error) {
+	for _, p := range providers {
+		live, err := p.LiveFromURL(u)
+		if errors.Is(err, ErrUnsupportedURL) {
+			continue
+		}
+		return p, live, err
+	}
+	return nil, nil, ErrUnsupportedURL
+}
+
+// Record notifies about the live stream and starts its download
+func Record(ctx context.Context, p Provider, live *common.ChannelLive, channel Channel) {
+	if ctx.Err() != nil {
+		return
+	}
+	event := notifier.EventRecording
+	if live.Upcoming {
+		event = notifier.EventWaiting
+	}
+	notifier.Notify(notifier.Event{Type: event, Live: *live, URL: p.WatchURL(live)})
+	go p.StartDownload(ctx, live, channel)
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"context"
+	"math/rand"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+// entry is the schedule of a single channel, or of a group of channels that a
+// BatchChecker checks in one request
+type entry struct {
+	provider Provider
+	name     string
+	channels []Channel
+	interval time.Duration
+	next     time.Time
+	running  bool
+}
+
+type checkResult struct {
+	key     string
+	checked time.Time
+}
+
+// RunScheduler checks every channel of the enabled providers on its own
+// interval until ctx is done. At most archive.concurrency checks run at once.
+func RunScheduler(ctx context.Context) {
+	entries := make(map[string]*entry)
+	done := make(chan checkResult)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	running := 0
+	for {
+		refreshEntries(entries)
+
+		now := time.Now()
+		for key, e := range entries {
+			if e.running || now.Before(e.next) || running >= concurrency() {
+				continue
+			}
+			e.running = true
+			running++
+			go func(key string, e entry) {
+				checkEntry(ctx, e)
+				done <- checkResult{key: key, checked: time.Now()}
+			}(key, *e)
+		}
+
+		select {
+		case <-ctx.Done():
+			// Let the running checks hand in their results
+			for ; running > 0; running-- {
+				<-done
+			}
+			return
+		case result := <-done:
+			running--
+			if e, exists := entries[result.key]; exists {
+				e.running = false
+				e.next = result.checked.Add(e.interval + jitter())
+				golog.Debug("[scheduler] next check of ", e.name, " at ", e.next.Format(time.RFC3339))
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshEntries adds the channels that are new in the config and removes the
+// ones that are gone, keeping the schedule of the others
+func refreshEntries(entries map[string]*entry) {
+	current := make(map[string]*entry)
+	for _, p := range providers {
+		if !p.Enabled() {
+			continue
+		}
+		_, batch := p.(BatchChecker)
+		for _, channel := range p.ListChannels() {
+			key := p.Name() + "/" + channel.ID
+			name := channel.Name
+			if batch {
+				// Channels sharing an interval are checked together
+				key = p.Name() + "/every " + interval(channel).String()
+				name = key
+			}
+			if e, exists := current[key]; exists {
+				e.channels = append(e.channels, channel)
+				continue
+			}
+			current[key] = &entry{
+				provider: p,
+				name:     name,
+				channels: []Channel{channel},
+				interval: interval(channel),
+			}
+		}
+	}
+
+	for key, e := range current {
+		if existing, exists := entries[key]; exists {
+			existing.channels = e.channels
+			// Don't wait out the old interval when the new one is shorter
+			if e.interval < existing.interval {
+				existing.next = existing.next.Add(e.interval - existing.interval)
+			}
+			existing.interval = e.interval
+			continue
+		}
+		e.next = time.Now().Add(jitter())
+		entries[key] = e
+	}
+	for key, e := range entries {
+		if _, exists := current[key]; !exists && !e.running {
+			delete(entries, key)
+		}
+	}
+}
+
+func checkEntry(ctx context.Context, e entry) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	if batch, ok := e.provider.(BatchChecker); ok {
+		golog.Info("[", e.provider.Name(), "] checking live: ", len(e.channels), " channels")
+		lives, err := batch.CheckLiveBatch(ctx, e.channels)
+		if err != nil {
+			golog.Error(err)
+		}
+		for _, channel := range e.channels {
+			recordLives(ctx, e.provider, lives[channel.ID], channel)
+		}
+		return
+	}
+
+	for _, channel := range e.channels {
+		golog.Info("[", e.provider.Name(), "] checking live: ", channel.Name)
+		lives, err := e.provider.CheckLive(ctx, channel)
+		if err != nil {
+			golog.Error(err)
+		}
+		recordLives(ctx, e.provider, lives, channel)
+	}
+}
+
+func recordLives(ctx context.Context, p Provider, lives []*common.ChannelLive, channel Channel) {
+	for _, live := range lives {
+		if p.ShouldRecord(live, channel) {
+			Record(ctx, p, live, channel)
+		}
+	}
+}
+
+func interval(channel Channel) time.Duration {
+	if channel.Interval > 0 {
+		return channel.Interval
+	}
+	checker := config.Get().Archive.Checker
+	if checker < 1 {
+		checker = 1
+	}
+	return time.Duration(checker) * time.Minute
+}
+
+func jitter() time.Duration {
+	maxJitter := config.Get().Archive.Jitter
+	if maxJitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maxJitter) * int64(time.Second)))
+}
+
+func concurrency() int {
+	if config.Get().Archive.Concurrency < 1 {
+		return 4
+	}
+	return config.Get().Archive.Concurrency
+}
@@ -0,0 +1,413 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"streamwatcher/provider"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+var (
+	helixURL = "https://api.twitch.tv/helix"
+	tokenURL = "https://id.twitch.tv/oauth2/token"
+)
+
+var (
+	appToken        string
+	appTokenExpires time.Time
+	appTokenLock    sync.Mutex
+
+	// seenMessages drops the retries of notifications that were already handled
+	seenMessages     = make(map[string]time.Time)
+	seenMessagesLock sync.Mutex
+
+	eventSubCtx = context.Background()
+)
+
+type eventSubSubscription struct {
+	ID        string            `json:"id,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport struct {
+		Method   string `json:"method"`
+		Callback string `json:"callback"`
+		Secret   string `json:"secret,omitempty"`
+	} `json:"transport"`
+}
+
+// StartEventSub keeps a stream.online and stream.offline subscription for
+// every configured channel, until ctx is done
+func StartEventSub(ctx context.Context) {
+	eventSubCtx = ctx
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		if err := syncSubscriptions(ctx); err != nil {
+			golog.Warn("[eventsub] failed to sync subscriptions: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func getAppToken(ctx context.Context) (string, error) {
+	appTokenLock.Lock()
+	defer appTokenLock.Unlock()
+
+	if appToken != "" && time.Until(appTokenExpires) > time.Minute {
+		return appToken, nil
+	}
+
+	form := url.Values{
+		"client_id":     {config.Get().Twitch.ClientID},
+		"client_secret": {config.Get().Twitch.ClientSecret},
+		"grant_type":    {"client_credentials"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("[eventsub] token request returned %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	appToken = token.AccessToken
+	appTokenExpires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return appToken, nil
+}
+
+func helixRequest(ctx context.Context, method string, path string, body any, out any) (int, error) {
+	token, err := getAppToken(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, helixURL+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Client-Id", config.Get().Twitch.ClientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("[eventsub] %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode, nil
+}
+
+// getUserIDs resolves logins to broadcaster ids, keyed by lowercase login
+func getUserIDs(ctx context.Context, logins []string) (map[string]string, error) {
+	ids := make(map[string]string)
+	for start := 0; start < len(logins); start += 100 {
+		end := min(start+100, len(logins))
+		query := url.Values{}
+		for _, login := range logins[start:end] {
+			query.Add("login", login)
+		}
+
+		var users struct {
+			Data []struct {
+				ID    string `json:"id"`
+				Login string `json:"login"`
+			} `json:"data"`
+		}
+		if _, err := helixRequest(ctx, http.MethodGet, "/users?"+query.Encode(), nil, &users); err != nil {
+			return nil, err
+		}
+		for _, user := range users.Data {
+			ids[strings.ToLower(user.Login)] = user.ID
+		}
+	}
+	return ids, nil
+}
+
+func listSubscriptions(ctx context.Context) ([]eventSubSubscription, error) {
+	var subscriptions []eventSubSubscription
+	cursor := ""
+	for {
+		path := "/eventsub/subscriptions"
+		if cursor != "" {
+			path += "?after=" + url.QueryEscape(cursor)
+		}
+		var page struct {
+			Data       []eventSubSubscription `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if _, err := helixRequest(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, page.Data...)
+		if page.Pagination.Cursor == "" {
+			return subscriptions, nil
+		}
+		cursor = page.Pagination.Cursor
+	}
+}
+
+func syncSubscriptions(ctx context.Context) error {
+	var logins []string
+	for _, channel := range config.Get().TwitchChannel {
+		logins = append(logins, channel.Name)
+	}
+	if len(logins) == 0 {
+		return nil
+	}
+
+	ids, err := getUserIDs(ctx, logins)
+	if err != nil {
+		return err
+	}
+	existing, err := listSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	callback := config.Get().Twitch.EventSubCallback
+	active := make(map[string]bool)
+	for _, subscription := range existing {
+		if subscription.Transport.Callback == callback && (subscription.Status == "enabled" || subscription.Status == "webhook_callback_verification_pending") {
+			active[subscription.Type+"/"+subscription.Condition["broadcaster_user_id"]] = true
+		}
+	}
+
+	for _, login := range logins {
+		id, exists := ids[strings.ToLower(login)]
+		if !exists {
+			golog.Warn("[eventsub] user not found: ", login)
+			continue
+		}
+		for _, eventType := range []string{"stream.online", "stream.offline"} {
+			if active[eventType+"/"+id] {
+				continue
+			}
+			subscription := eventSubSubscription{
+				Type:      eventType,
+				Version:   "1",
+				Condition: map[string]string{"broadcaster_user_id": id},
+			}
+			subscription.Transport.Method = "webhook"
+			subscription.Transport.Callback = callback
+			subscription.Transport.Secret = config.Get().Twitch.EventSubSecret
+
+			status, err := helixRequest(ctx, http.MethodPost, "/eventsub/subscriptions", subscription, nil)
+			if status == http.StatusConflict {
+				continue
+			}
+			if err != nil {
+				golog.Warn("[eventsub] failed to subscribe ", eventType, " for ", login, ": ", err)
+				continue
+			}
+			golog.Info("[eventsub] subscribed ", eventType, " for ", login)
+		}
+	}
+	return nil
+}
+
+// EventSubHandler receives the webhook calls of Twitch EventSub
+func EventSubHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.Get().Twitch.EventSub {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	messageID := r.Header.Get("Twitch-Eventsub-Message-Id")
+	timestamp := r.Header.Get("Twitch-Eventsub-Message-Timestamp")
+	if !validEventSubSignature(config.Get().Twitch.EventSubSecret, messageID, timestamp, body, r.Header.Get("Twitch-Eventsub-Message-Signature")) {
+		golog.Warn("[eventsub] rejecting message with an invalid signature")
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+	sent, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil || time.Since(sent) > 10*time.Minute {
+		http.Error(w, "Message is too old", http.StatusForbidden)
+		return
+	}
+	if alreadySeen(messageID) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var message struct {
+		Challenge    string               `json:"challenge"`
+		Subscription eventSubSubscription `json:"subscription"`
+		Event        struct {
+			BroadcasterUserLogin string `json:"broadcaster_user_login"`
+			Type                 string `json:"type"`
+		} `json:"event"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Header.Get("Twitch-Eventsub-Message-Type") {
+	case "webhook_callback_verification":
+		golog.Info("[eventsub] verified ", message.Subscription.Type, " subscription")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(message.Challenge))
+	case "notification":
+		w.WriteHeader(http.StatusNoContent)
+		login := message.Event.BroadcasterUserLogin
+		switch message.Subscription.Type {
+		case "stream.online":
+			golog.Info("[eventsub] ", login, " went online")
+			go handleOnline(eventSubCtx, login)
+		case "stream.offline":
+			golog.Info("[eventsub] ", login, " went offline")
+			if channel, found := findChannel(login); found {
+				if streamID := observeOffline(channel.Name); streamID != "" {
+					go streamEnded(eventSubCtx, channel, streamID)
+				}
+			}
+		}
+	case "revocation":
+		golog.Warn("[eventsub] ", message.Subscription.Type, " subscription revoked: ", message.Subscription.Status)
+		w.WriteHeader(http.StatusNoContent)
+		go func() {
+			if err := syncSubscriptions(eventSubCtx); err != nil {
+				golog.Warn("[eventsub] failed to sync subscriptions: ", err)
+			}
+		}()
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validEventSubSignature checks "sha256=<hex hmac of id + timestamp + body>"
+func validEventSubSignature(secret string, messageID string, timestamp string, body []byte, header string) bool {
+	signature, found := strings.CutPrefix(header, "sha256=")
+	if !found || secret == "" {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID + timestamp))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func alreadySeen(messageID string) bool {
+	seenMessagesLock.Lock()
+	defer seenMessagesLock.Unlock()
+
+	for id, at := range seenMessages {
+		if time.Since(at) > 10*time.Minute {
+			delete(seenMessages, id)
+		}
+	}
+	if _, exists := seenMessages[messageID]; exists {
+		return true
+	}
+	seenMessages[messageID] = time.Now()
+	return false
+}
+
+// handleOnline starts recording a channel that went online, the same way the
+// poller does once it sees the stream
+func handleOnline(ctx context.Context, login string) {
+	var p Provider
+	channel, found := findChannel(login)
+	if !found {
+		golog.Debug("[eventsub] event for unknown channel: ", login)
+		return
+	}
+
+	// The stream can take a moment to show up in GQL after the event
+	for attempt := 0; attempt < 5; attempt++ {
+		lives, err := p.CheckLive(ctx, channel)
+		if err != nil {
+			golog.Warn("[eventsub] failed to check ", login, ": ", err)
+		}
+		if len(lives) > 0 {
+			recordLive(ctx, p, lives[0], channel)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+	golog.Warn("[eventsub] ", login, " went online but no stream was found")
+}
+
+func findChannel(login string) (provider.Channel, bool) {
+	for _, channel := range (Provider{}).ListChannels() {
+		if strings.EqualFold(channel.Name, login) {
+			return channel, true
+		}
+	}
+	return provider.Channel{}, false
+}
+
+func recordLive(ctx context.Context, p Provider, live *common.ChannelLive, channel provider.Channel) {
+	if p.ShouldRecord(live, channel) {
+		provider.Record(ctx, p, live, channel)
+	}
+}
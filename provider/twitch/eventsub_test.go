@@ -0,0 +1,286 @@
+package twitch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"streamwatcher/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCallback = "https://watcher.example/api/eventsub/twitch"
+
+func setEventSubConfig(t *testing.T) {
+	t.Helper()
+	previous := config.Get()
+	config.Set(&config.Config{
+		TwitchChannel: []config.TwitchChannel{
+			{Name: "StreamerOne"},
+			{Name: "streamertwo"},
+			{Name: "gone"},
+		},
+		Twitch: config.TwitchConfig{
+			ClientID:         "client-id",
+			ClientSecret:     "client-secret",
+			EventSub:         true,
+			EventSubCallback: testCallback,
+			EventSubSecret:   "eventsub-secret",
+		},
+	})
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+// serveHelix serves the token, users and subscriptions endpoints of Twitch.
+// The subscriptions created are sent on the returned channel.
+func serveHelix(t *testing.T) chan eventSubSubscription {
+	t.Helper()
+	created := make(chan eventSubSubscription, 16)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"app-token","expires_in":3600}`))
+	})
+	mux.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		ids := map[string]string{"streamerone": "101", "streamertwo": "102"}
+		var data []map[string]string
+		for _, login := range r.URL.Query()["login"] {
+			if id, exists := ids[strings.ToLower(login)]; exists {
+				data = append(data, map[string]string{"id": id, "login": strings.ToLower(login)})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	})
+	mux.HandleFunc("GET /eventsub/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		// Two pages: an active subscription on ours, then one for another callback
+		if r.URL.Query().Get("after") == "" {
+			w.Write([]byte(`{"data":[{"id":"a","status":"enabled","type":"stream.online","version":"1","condition":{"broadcaster_user_id":"101"},"transport":{"method":"webhook","callback":"` + testCallback + `"}}],"pagination":{"cursor":"page2"}}`))
+			return
+		}
+		w.Write([]byte(`{"data":[{"id":"b","status":"enabled","type":"stream.offline","version":"1","condition":{"broadcaster_user_id":"101"},"transport":{"method":"webhook","callback":"https://elsewhere.example/callback"}}],"pagination":{}}`))
+	})
+	mux.HandleFunc("POST /eventsub/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer app-token" || r.Header.Get("Client-Id") != "client-id" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var subscription eventSubSubscription
+		if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created <- subscription
+
+		// Twitch answers 409 when the subscription already exists
+		if subscription.Type == "stream.offline" && subscription.Condition["broadcaster_user_id"] == "102" {
+			http.Error(w, "subscription already exists", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	server := httptest.NewServer(mux)
+	previousHelix, previousToken := helixURL, tokenURL
+	helixURL, tokenURL = server.URL, server.URL+"/token"
+	appTokenLock.Lock()
+	appToken, appTokenExpires = "", time.Time{}
+	appTokenLock.Unlock()
+	t.Cleanup(func() {
+		helixURL, tokenURL = previousHelix, previousToken
+		server.Close()
+	})
+	return created
+}
+
+func signEventSub(secret string, messageID string, timestamp string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(messageID + timestamp + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func eventSubRequest(messageType string, messageID string, timestamp string, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/eventsub/twitch", strings.NewReader(body))
+	req.Header.Set("Twitch-Eventsub-Message-Id", messageID)
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Message-Type", messageType)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", signEventSub("eventsub-secret", messageID, timestamp, body))
+	return req
+}
+
+func TestValidEventSubSignature(t *testing.T) {
+	body := []byte(`{"challenge":"abc"}`)
+	timestamp := "2026-01-01T00:00:00.123456789Z"
+	valid := signEventSub("eventsub-secret", "message-1", timestamp, string(body))
+	tests := []struct {
+		name      string
+		secret    string
+		messageID string
+		header    string
+		want      bool
+	}{
+		{"valid", "eventsub-secret", "message-1", valid, true},
+		{"other secret", "other", "message-1", valid, false},
+		{"other message id", "eventsub-secret", "message-2", valid, false},
+		{"no secret configured", "", "message-1", signEventSub("", "message-1", timestamp, string(body)), false},
+		{"other algorithm", "eventsub-secret", "message-1", strings.Replace(valid, "sha256=", "sha1=", 1), false},
+		{"not hex", "eventsub-secret", "message-1", "sha256=zz", false},
+		{"missing", "eventsub-secret", "message-1", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validEventSubSignature(tt.secret, tt.messageID, timestamp, body, tt.header); got != tt.want {
+				t.Errorf("validEventSubSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlreadySeen(t *testing.T) {
+	seenMessagesLock.Lock()
+	seenMessages = map[string]time.Time{"expired": time.Now().Add(-11 * time.Minute)}
+	seenMessagesLock.Unlock()
+
+	if alreadySeen("message-1") {
+		t.Fatal("first delivery reported as seen")
+	}
+	if !alreadySeen("message-1") {
+		t.Fatal("retried delivery not reported as seen")
+	}
+	if alreadySeen("expired") {
+		t.Fatal("message older than 10 minutes still reported as seen")
+	}
+}
+
+func TestEventSubHandlerVerification(t *testing.T) {
+	setEventSubConfig(t)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	body := `{"challenge":"pogchamp-kappa-360noscope-vohiyo","subscription":{"type":"stream.online","version":"1","condition":{"broadcaster_user_id":"101"},"transport":{"method":"webhook","callback":"` + testCallback + `"}}}`
+
+	recorder := httptest.NewRecorder()
+	EventSubHandler(recorder, eventSubRequest("webhook_callback_verification", "verify-1", now, body))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "pogchamp-kappa-360noscope-vohiyo" {
+		t.Fatalf("got %d %q, want the challenge echoed", recorder.Code, recorder.Body.String())
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain" {
+		t.Errorf("Content-Type = %q, want text/plain", contentType)
+	}
+}
+
+func TestEventSubHandlerRejects(t *testing.T) {
+	setEventSubConfig(t)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	body := `{"challenge":"abc"}`
+
+	unsigned := eventSubRequest("webhook_callback_verification", "reject-1", now, body)
+	unsigned.Header.Set("Twitch-Eventsub-Message-Signature", signEventSub("other", "reject-1", now, body))
+	old := time.Now().Add(-11 * time.Minute).UTC().Format(time.RFC3339Nano)
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"invalid signature", unsigned, http.StatusForbidden},
+		{"too old", eventSubRequest("webhook_callback_verification", "reject-2", old, body), http.StatusForbidden},
+		{"not a post", httptest.NewRequest(http.MethodGet, "/api/eventsub/twitch", nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			EventSubHandler(recorder, tt.req)
+			if recorder.Code != tt.want {
+				t.Fatalf("got %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+
+	// A retried verification is acknowledged without echoing the challenge again
+	first := httptest.NewRecorder()
+	EventSubHandler(first, eventSubRequest("webhook_callback_verification", "retried", now, body))
+	retry := httptest.NewRecorder()
+	EventSubHandler(retry, eventSubRequest("webhook_callback_verification", "retried", now, body))
+	if first.Body.String() != "abc" || retry.Code != http.StatusNoContent || retry.Body.Len() != 0 {
+		t.Fatalf("got %d %q then %d %q, want the challenge then 204", first.Code, first.Body.String(), retry.Code, retry.Body.String())
+	}
+
+	config.Set(&config.Config{})
+	recorder := httptest.NewRecorder()
+	EventSubHandler(recorder, eventSubRequest("webhook_callback_verification", "disabled", now, body))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("disabled: got %d, want 404", recorder.Code)
+	}
+}
+
+func TestEventSubHandlerOfflineNotification(t *testing.T) {
+	setEventSubConfig(t)
+	channelStatesLock.Lock()
+	channelStates = make(map[string]*ChannelState)
+	channelState("StreamerOne").set(StateLiveRecording, "stream-1")
+	channelStatesLock.Unlock()
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	body := `{"subscription":{"type":"stream.offline","version":"1"},"event":{"broadcaster_user_id":"101","broadcaster_user_login":"streamerone"}}`
+	recorder := httptest.NewRecorder()
+	EventSubHandler(recorder, eventSubRequest("notification", "offline-1", now, body))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("got %d, want 204", recorder.Code)
+	}
+
+	states := ChannelStates()
+	if len(states) != 1 || states[0].State != StateEnded || states[0].StreamID != "stream-1" {
+		t.Fatalf("states = %+v, want StreamerOne ended with stream-1", states)
+	}
+}
+
+func TestEventSubHandlerRevocation(t *testing.T) {
+	setEventSubConfig(t)
+	created := serveHelix(t)
+
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	body := `{"subscription":{"id":"a","status":"authorization_revoked","type":"stream.online","version":"1","condition":{"broadcaster_user_id":"101"}}}`
+	recorder := httptest.NewRecorder()
+	EventSubHandler(recorder, eventSubRequest("revocation", "revoked-1", now, body))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("got %d, want 204", recorder.Code)
+	}
+
+	// The revocation resyncs the subscriptions in the background
+	for i := 0; i < 3; i++ {
+		select {
+		case <-created:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d subscriptions created after the revocation, want 3", i)
+		}
+	}
+}
+
+func TestSyncSubscriptions(t *testing.T) {
+	setEventSubConfig(t)
+	created := serveHelix(t)
+
+	if err := syncSubscriptions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(created)
+
+	// stream.online of 101 is already active on our callback, "gone" has no user
+	var got []string
+	for subscription := range created {
+		got = append(got, subscription.Type+"/"+subscription.Condition["broadcaster_user_id"])
+		if subscription.Version != "1" || subscription.Transport.Method != "webhook" ||
+			subscription.Transport.Callback != testCallback || subscription.Transport.Secret != "eventsub-secret" {
+			t.Errorf("subscription %+v, want a webhook to the callback with the secret", subscription)
+		}
+	}
+	sort.Strings(got)
+	want := []string{"stream.offline/101", "stream.offline/102", "stream.online/102"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("created %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package twitch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var gqlURL = "https://gql.twitch.tv/gql"
+
+type gqlError struct {
+	Message string `json:"message"`
+	Path    []any  `json:"path"`
+}
+
+type gqlErrors []gqlError
+
+func (e gqlErrors) Error() string {
+	var messages []string
+	for _, err := range e {
+		if len(err.Path) > 0 {
+			messages = append(messages, fmt.Sprintf("%s (%v)", err.Message, err.Path))
+		} else {
+			messages = append(messages, err.Message)
+		}
+	}
+	return "[twitch] gql errors: " + strings.Join(messages, "; ")
+}
+
+// gqlRequest runs a query and decodes its data into out. When the response
+// carries both data and errors, the data is decoded and the errors returned
+// as gqlErrors, so callers can still use the partial result.
+func gqlRequest(ctx context.Context, query string, variables map[string]any, out any) error {
+	reqBody, err := json.Marshal(map[string]any{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gqlURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("client-id", "kimne78kx3ncx6brgo4mv6wki5h1ko")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("[twitch] gql returned status %d", resp.StatusCode)
+	}
+
+	var resData struct {
+		Data   json.RawMessage `json:"data"`
+		Errors gqlErrors       `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&resData); err != nil {
+		return fmt.Errorf("[twitch] failed to decode gql response: %w", err)
+	}
+	if len(resData.Data) == 0 || string(resData.Data) == "null" {
+		if len(resData.Errors) > 0 {
+			return resData.Errors
+		}
+		return fmt.Errorf("[twitch] gql response has no data")
+	}
+	if err := json.Unmarshal(resData.Data, out); err != nil {
+		return fmt.Errorf("[twitch] failed to decode gql data: %w", err)
+	}
+	if len(resData.Errors) > 0 {
+		return resData.Errors
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package twitch
+
+import (
+	"sort"
+	"streamwatcher/common"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+const (
+	StateOffline         = "Offline"
+	StateLiveRecording   = "LiveRecording"
+	StateLiveFilteredOut = "LiveFilteredOut"
+	StateEnded           = "Ended"
+)
+
+// ChannelState is what the watcher last saw of a channel. A stream is
+// identified by its stream ID, so a restarted stream is recorded again.
+type ChannelState struct {
+	Channel   string    `json:"channel"`
+	State     string    `json:"state"`
+	StreamID  string    `json:"stream_id"`
+	Title     string    `json:"title"`
+	Since     time.Time `json:"since"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var (
+	channelStates     = make(map[string]*ChannelState)
+	channelStatesLock sync.Mutex
+)
+
+// ChannelStates returns a copy of the state of every channel seen so far
+func ChannelStates() []ChannelState {
+	channelStatesLock.Lock()
+	defer channelStatesLock.Unlock()
+
+	states := make([]ChannelState, 0, len(channelStates))
+	for _, state := range channelStates {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Channel < states[j].Channel
+	})
+	return states
+}
+
+// channelState returns the state of a channel, creating it as Offline.
+// The caller must hold channelStatesLock.
+func channelState(name string) *ChannelState {
+	key := strings.ToLower(name)
+	state, exists := channelStates[key]
+	if !exists {
+		state = &ChannelState{Channel: name, State: StateOffline, Since: time.Now().UTC()}
+		channelStates[key] = state
+	}
+	state.UpdatedAt = time.Now().UTC()
+	return state
+}
+
+// set moves a channel to a new state. The caller must hold channelStatesLock.
+func (state *ChannelState) set(status string, streamID string) {
+	if state.State == status && state.StreamID == streamID {
+		return
+	}
+	golog.Debug("[twitch] ", state.Channel, ": ", state.State, " -> ", status, " (", streamID, ")")
+	state.State = status
+	state.StreamID = streamID
+	state.Since = state.UpdatedAt
+}
+
+// observeOffline records that a channel has no stream. A recorded stream ends,
+// any other state goes back to offline. It returns the ID of the stream that
+// just ended, if any.
+func observeOffline(name string) string {
+	channelStatesLock.Lock()
+	defer channelStatesLock.Unlock()
+
+	state := channelState(name)
+	switch state.State {
+	case StateLiveRecording:
+		state.set(StateEnded, state.StreamID)
+		return state.StreamID
+	case StateEnded:
+	default:
+		state.set(StateOffline, "")
+	}
+	return ""
+}
+
+// observeLive records a live stream and reports whether it should be recorded.
+// The filter is checked on every call, so a stream that changes its title to a
+// matching one is still picked up. A stream being recorded stays recorded
+// when its title stops matching.
+func observeLive(name string, live *common.ChannelLive, matches bool) bool {
+	channelStatesLock.Lock()
+	defer channelStatesLock.Unlock()
+
+	state := channelState(name)
+	state.Title = live.Title
+	if !matches {
+		if state.State == StateLiveRecording && state.StreamID == live.VideoID {
+			return false
+		}
+		state.set(StateLiveFilteredOut, live.VideoID)
+		return false
+	}
+
+	recording := common.IsVideoIDInDownloadJobs(live.VideoID)
+	state.set(StateLiveRecording, live.VideoID)
+	return !recording
+}
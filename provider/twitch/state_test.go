@@ -0,0 +1,64 @@
+package twitch
+
+import (
+	"streamwatcher/common"
+	"testing"
+)
+
+func TestChannelStateTransitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		streamID string
+		// An empty live is an offline observation
+		live      string
+		matches   bool
+		jobStatus string
+		want      string
+		wantID    string
+		// What observeLive reports, or the stream that observeOffline ended
+		wantRecord bool
+		wantEnded  string
+	}{
+		{name: "offline goes live", state: StateOffline, live: "s1", matches: true, want: StateLiveRecording, wantID: "s1", wantRecord: true},
+		{name: "offline goes live filtered out", state: StateOffline, live: "s1", want: StateLiveFilteredOut, wantID: "s1"},
+		{name: "recording is seen again", state: StateLiveRecording, streamID: "s1", live: "s1", matches: true, jobStatus: "Recording", want: StateLiveRecording, wantID: "s1"},
+		{name: "recording whose job failed is retried", state: StateLiveRecording, streamID: "s1", live: "s1", matches: true, jobStatus: "Error", want: StateLiveRecording, wantID: "s1", wantRecord: true},
+		{name: "recording stops matching the filter", state: StateLiveRecording, streamID: "s1", live: "s1", jobStatus: "Recording", want: StateLiveRecording, wantID: "s1"},
+		{name: "filtered out starts matching", state: StateLiveFilteredOut, streamID: "s1", live: "s1", matches: true, want: StateLiveRecording, wantID: "s1", wantRecord: true},
+		{name: "new stream after the recorded one", state: StateEnded, streamID: "s1", live: "s2", matches: true, want: StateLiveRecording, wantID: "s2", wantRecord: true},
+		{name: "new stream filtered out after the recorded one", state: StateLiveRecording, streamID: "s1", live: "s2", want: StateLiveFilteredOut, wantID: "s2"},
+		{name: "recording ends", state: StateLiveRecording, streamID: "s1", want: StateEnded, wantID: "s1", wantEnded: "s1"},
+		{name: "ended stays ended", state: StateEnded, streamID: "s1", want: StateEnded, wantID: "s1"},
+		{name: "filtered out ends", state: StateLiveFilteredOut, streamID: "s1", want: StateOffline},
+		{name: "offline stays offline", state: StateOffline, want: StateOffline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channelStatesLock.Lock()
+			channelStates = make(map[string]*ChannelState)
+			channelState("Streamer").set(tt.state, tt.streamID)
+			channelStatesLock.Unlock()
+
+			common.DownloadJobsLock.Lock()
+			common.DownloadJobs = make(map[string]*common.DownloadJob)
+			if tt.jobStatus != "" {
+				common.DownloadJobs[tt.streamID] = &common.DownloadJob{VideoID: tt.streamID, Status: tt.jobStatus}
+			}
+			common.DownloadJobsLock.Unlock()
+
+			if tt.live != "" {
+				if record := observeLive("Streamer", &common.ChannelLive{VideoID: tt.live}, tt.matches); record != tt.wantRecord {
+					t.Errorf("observeLive = %v, want %v", record, tt.wantRecord)
+				}
+			} else if ended := observeOffline("Streamer"); ended != tt.wantEnded {
+				t.Errorf("observeOffline = %q, want %q", ended, tt.wantEnded)
+			}
+
+			states := ChannelStates()
+			if len(states) != 1 || states[0].State != tt.want || states[0].StreamID != tt.wantID {
+				t.Errorf("states = %+v, want %s %q", states, tt.want, tt.wantID)
+			}
+		})
+	}
+}
@@ -1,15 +1,13 @@
 package twitch
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"context"
+	"net/url"
 	"streamwatcher/common"
 	"streamwatcher/config"
-	"streamwatcher/helpers/discord"
-	"streamwatcher/helpers/streamlink"
-	"streamwatcher/helpers/ytdlp"
+	"streamwatcher/helpers/downloader"
+	"streamwatcher/provider"
+	"strings"
 	"time"
 
 	"github.com/kataras/golog"
@@ -22,117 +20,225 @@ type StreamInfo struct {
 }
 
 type User struct {
+	Login           string      `json:"login"`
 	ProfileImageURL string      `json:"profileImageURL"`
 	Stream          *StreamInfo `json:"stream"`
 }
 
-type ResponseData struct {
-	Data struct {
-		User User `json:"user"`
-	} `json:"data"`
-}
+const usersQuery = `query($logins: [String!]) {
+	users(logins: $logins) {
+		login
+		profileImageURL(width: 50)
+		stream {
+			id
+			title
+			previewImageURL(height: 720, width: 1280)
+		}
+	}
+}`
 
 func GetChannelInfo(username string) (*common.ChannelLive, error) {
-	url := "https://gql.twitch.tv/gql"
-	query := fmt.Sprintf(`query {
-        user(login: "%s") {
-            profileImageURL(width:50)
-            stream {
-                id
-                title
-                previewImageURL(height: 720, width:1280)
-            }
-        }
-    }`, username)
-
-	reqBody, err := json.Marshal(map[string]interface{}{
-		"query":     query,
-		"variables": map[string]interface{}{},
-	})
-	if err != nil {
-		return nil, err
+	lives, _, err := GetChannelsInfo(context.Background(), []string{username})
+	return lives[username], err
+}
+
+// GetChannelsInfo checks all channels in a single request. The results are
+// keyed by the usernames as given: lives holds the live channels, offline the
+// ones known to be offline. Channels that errored or weren't found are in
+// neither, their errors are logged and don't fail the others.
+//
+// GraphQL nulls a field whose resolver failed, so a null stream only means
+// offline when no error points at that user.
+func GetChannelsInfo(ctx context.Context, usernames []string) (lives map[string]*common.ChannelLive, offline map[string]bool, err error) {
+	lives = make(map[string]*common.ChannelLive)
+	offline = make(map[string]bool)
+	if len(usernames) == 0 {
+		return lives, offline, nil
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return nil, err
+	var data struct {
+		Users []*User `json:"users"`
+	}
+	err = gqlRequest(ctx, usersQuery, map[string]any{"logins": usernames}, &data)
+	errs, partial := err.(gqlErrors)
+	if err != nil && !partial {
+		return lives, offline, err
+	} else if err != nil {
+		golog.Warn(err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("client-id", "kimne78kx3ncx6brgo4mv6wki5h1ko")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	users := make(map[string]*User)
+	for _, user := range data.Users {
+		if user != nil {
+			users[strings.ToLower(user.Login)] = user
+		}
 	}
-	defer resp.Body.Close()
 
-	var resData ResponseData
-	if err := json.NewDecoder(resp.Body).Decode(&resData); err != nil {
-		return nil, err
+	// The users come back in the order of the logins
+	failed := make(map[string]bool)
+	// An error that doesn't point at a user could have nulled any stream
+	unsure := false
+	for _, e := range errs {
+		index, ok := userIndex(e.Path)
+		if !ok {
+			unsure = true
+			continue
+		}
+		if index < len(usernames) {
+			failed[strings.ToLower(usernames[index])] = true
+		}
+		if index < len(data.Users) && data.Users[index] != nil {
+			failed[strings.ToLower(data.Users[index].Login)] = true
+		}
 	}
 
-	streamInfo := resData.Data.User.Stream
-	streamProfilePic := resData.Data.User.ProfileImageURL
-	DateCrawled := time.Now().UTC().Format(time.RFC3339Nano)
+	dateCrawled := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, username := range usernames {
+		if failed[strings.ToLower(username)] {
+			golog.Warn("[twitch] failed to check ", username, ", keeping its state")
+			continue
+		}
+		user, exists := users[strings.ToLower(username)]
+		if !exists {
+			golog.Warn("[twitch] user not found: ", username)
+			continue
+		}
+		if user.Stream == nil {
+			if !unsure {
+				offline[username] = true
+			}
+			continue
+		}
+		lives[username] = &common.ChannelLive{
+			Title:          user.Stream.Title,
+			ChannelID:      username,
+			ThumbnailUrl:   user.Stream.PreviewImageURL,
+			VideoID:        user.Stream.ID,
+			ChannelName:    username,
+			ChannelPicture: user.ProfileImageURL,
+			DateCrawled:    dateCrawled,
+			Provider:       "twitch",
+		}
+	}
+	return lives, offline, nil
+}
 
-	if streamInfo == nil {
-		return nil, nil
+// userIndex returns the index of the user an error path like
+// ["users", 3, "stream"] points at
+func userIndex(path []any) (int, bool) {
+	if len(path) < 2 || path[0] != "users" {
+		return 0, false
+	}
+	index, ok := path[1].(float64)
+	if !ok || index < 0 {
+		return 0, false
 	}
+	return int(index), true
+}
 
-	return &common.ChannelLive{
-		Title:          streamInfo.Title,
-		ChannelID:      username,
-		ThumbnailUrl:   streamInfo.PreviewImageURL,
-		VideoID:        streamInfo.ID,
-		ChannelName:    username,
-		ChannelPicture: streamProfilePic,
-		DateCrawled:    DateCrawled,
-	}, nil
+// Provider polls the Twitch GQL api for the configured channels
+type Provider struct{}
+
+func init() {
+	provider.Register(Provider{})
 }
 
-func CheckLiveAllChannel() {
-	for i, channel := range config.AppConfig.TwitchChannel {
-		if common.IsChannelIDInDownloadJobsAndFinished(channel.Name) {
-			golog.Debug("[twitch] ", channel.Name, " is already in download jobs")
-			break
-		}
-		golog.Info("[twitch] Checking if ", channel.Name, " is live")
-		channelLive, err := GetChannelInfo(channel.Name)
-		if err != nil {
-			golog.Error(err)
-		}
-		if channelLive != nil {
-			if common.IsChannelIDInDownloadJobsAndFinished(channelLive.ChannelName) {
-				golog.Debug("[twitch] ", channel.Name, " is already in download jobs")
-			} else {
-				videoInRegex := common.CheckVideoRegex(channelLive.Title, channel.Filters)
-				if videoInRegex {
-					golog.Info("[twitch] ", channel.Name, " is live: ", channelLive.Title)
-					discord.SendNotificationWebhook(channel.Name, channelLive.Title, "https://twitch.tv"+channel.Name, channelLive.ThumbnailUrl, "Recording")
-					go func() {
-						TwitchStartDownload(channel.Name, channelLive, channel.OutPath)
-					}()
-				} else {
-					golog.Debug("[twitch] ", channel.Name, " is live but not in filter")
-				}
+func (Provider) Name() string {
+	return "twitch"
+}
+
+func (Provider) Enabled() bool {
+	return config.Get().Archive.Twitch
+}
+
+func (Provider) ListChannels() []provider.Channel {
+	var channels []provider.Channel
+	for _, channel := range config.Get().TwitchChannel {
+		channels = append(channels, provider.Channel{
+			ID:         channel.Name,
+			Name:       channel.Name,
+			Filters:    channel.Filters,
+			OutPath:    channel.OutPath,
+			Downloader: channel.Downloader,
+			Interval:   time.Duration(channel.Interval) * time.Minute,
+			Options:    channel,
+		})
+	}
+	return channels
+}
+
+func (p Provider) CheckLive(ctx context.Context, channel provider.Channel) ([]*common.ChannelLive, error) {
+	lives, err := p.CheckLiveBatch(ctx, []provider.Channel{channel})
+	return lives[channel.ID], err
+}
+
+// CheckLiveBatch checks every channel, also the ones being recorded, so that
+// the end of their stream is noticed
+func (Provider) CheckLiveBatch(ctx context.Context, channels []provider.Channel) (map[string][]*common.ChannelLive, error) {
+	var usernames []string
+	for _, channel := range channels {
+		usernames = append(usernames, channel.Name)
+	}
+
+	golog.Debug("[twitch] checking ", len(usernames), " channels in one request")
+	infos, offline, err := GetChannelsInfo(ctx, usernames)
+	lives := make(map[string][]*common.ChannelLive)
+	if err != nil {
+		return lives, err
+	}
+	for i, username := range usernames {
+		live, exists := infos[username]
+		if !exists {
+			// A channel that errored may still be live, keep its state
+			if !offline[username] {
+				continue
 			}
-		} else {
-			golog.Debug("[twitch] ", channel.Name, " is not live")
+			if streamID := observeOffline(username); streamID != "" {
+				go streamEnded(ctx, channels[i], streamID)
+			}
+			continue
 		}
-		if i < len(config.AppConfig.TwitchChannel)-1 {
-			golog.Debug("[twitch] Waiting ", config.AppConfig.Archive.Checker, " minutes before checking next channel")
-			time.Sleep(time.Duration(config.AppConfig.Archive.Checker) * time.Minute)
+		lives[username] = []*common.ChannelLive{live}
+	}
+	return lives, nil
+}
+
+func (Provider) ShouldRecord(live *common.ChannelLive, channel provider.Channel) bool {
+	matches := common.CheckVideoRegex(live.Title, channel.Filters)
+	if !observeLive(channel.Name, live, matches) {
+		if matches {
+			golog.Debug("[twitch] ", channel.Name, " stream is already in download jobs: ", live.VideoID)
+		} else {
+			golog.Debug("[twitch] ", channel.Name, " is live but not in filter")
 		}
+		return false
 	}
+	golog.Info("[twitch] ", channel.Name, " is live: ", live.Title)
+	return true
 }
 
-func TwitchStartDownload(videoID string, channelLive *common.ChannelLive, outPath string) {
-	golog.Info("[twitch] Added task for channel: ", channelLive.ChannelName)
+func (p Provider) StartDownload(ctx context.Context, live *common.ChannelLive, channel provider.Channel) {
+	golog.Info("[twitch] Added task for channel: ", live.ChannelName)
+
+	name := channel.Downloader
+	if name == "" && config.Get().Archive.TwitchUsingStreamlink {
+		name = "streamlink"
+	} else if name == "" {
+		name = "yt-dlp"
+	}
+	downloader.StartDownload(ctx, name, p.WatchURL(live), []string{}, live, channel.OutPath)
+}
+
+func (Provider) WatchURL(live *common.ChannelLive) string {
+	if live.VOD {
+		return "https://www.twitch.tv/videos/" + live.VideoID
+	}
+	return "https://twitch.tv/" + live.ChannelName
+}
 
-	if config.AppConfig.Archive.TwitchUsingStreamlink {
-		streamlink.StartDownload("https://twitch.tv/"+channelLive.ChannelName, []string{}, channelLive, outPath)
-	} else {
-		ytdlp.StartDownload("https://twitch.tv/"+channelLive.ChannelName, []string{}, channelLive, outPath)
+func (Provider) LiveFromURL(u *url.URL) (*common.ChannelLive, error) {
+	if u.Host != "twitch.tv" && u.Host != "www.twitch.tv" {
+		return nil, provider.ErrUnsupportedURL
 	}
+	return GetChannelInfo(strings.TrimPrefix(u.Path, "/"))
 }
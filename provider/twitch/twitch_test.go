@@ -0,0 +1,78 @@
+package twitch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"streamwatcher/provider"
+	"testing"
+)
+
+// serveGQL answers every GQL request with a fixture
+func serveGQL(t *testing.T, fixture string) {
+	t.Helper()
+	body, err := os.ReadFile("testdata/" + fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	previous := gqlURL
+	gqlURL = server.URL
+	t.Cleanup(func() {
+		gqlURL = previous
+		server.Close()
+	})
+}
+
+func TestGetChannelsInfoPartialErrors(t *testing.T) {
+	serveGQL(t, "users_partial.json")
+
+	usernames := []string{"LiveChannel", "OfflineChannel", "BrokenChannel", "MissingChannel"}
+	lives, offline, err := GetChannelsInfo(context.Background(), usernames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, exists := lives["LiveChannel"]
+	if !exists || live.VideoID != "41375541868" || live.Title != "Speedrun attempts | !schedule" {
+		t.Errorf("lives[LiveChannel] = %+v", live)
+	}
+	if len(lives) != 1 {
+		t.Errorf("lives = %v, want LiveChannel only", lives)
+	}
+	// The stream of BrokenChannel is null because its resolver failed
+	if len(offline) != 1 || !offline["OfflineChannel"] {
+		t.Errorf("offline = %v, want OfflineChannel only", offline)
+	}
+}
+
+func TestCheckLiveBatchKeepsErroredChannels(t *testing.T) {
+	serveGQL(t, "users_partial.json")
+	channelStatesLock.Lock()
+	channelStates = make(map[string]*ChannelState)
+	channelState("BrokenChannel").set(StateLiveRecording, "stream-1")
+	channelState("OfflineChannel").set(StateLiveRecording, "stream-2")
+	channelStatesLock.Unlock()
+
+	var channels []provider.Channel
+	for _, name := range []string{"LiveChannel", "OfflineChannel", "BrokenChannel"} {
+		channels = append(channels, provider.Channel{ID: name, Name: name})
+	}
+	if _, err := (Provider{}).CheckLiveBatch(context.Background(), channels); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"BrokenChannel":  StateLiveRecording,
+		"OfflineChannel": StateEnded,
+	}
+	for _, state := range ChannelStates() {
+		if state.State != want[state.Channel] {
+			t.Errorf("%s is %s, want %s", state.Channel, state.State, want[state.Channel])
+		}
+	}
+}
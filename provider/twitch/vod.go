@@ -0,0 +1,118 @@
+package twitch
+
+import (
+	"context"
+	"streamwatcher/common"
+	"streamwatcher/config"
+	"streamwatcher/provider"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+const videosQuery = `query($login: String!) {
+	user(login: $login) {
+		profileImageURL(width: 50)
+		videos(first: 10, type: ARCHIVE, sort: TIME) {
+			edges {
+				node {
+					id
+					title
+					previewThumbnailURL(width: 1280, height: 720)
+					broadcastIdentifier {
+						id
+					}
+				}
+			}
+		}
+	}
+}`
+
+// vodLookups is how often, and how far apart, the VOD of an ended stream is
+// looked for. Twitch can take a few minutes to publish it.
+var (
+	vodLookups     = 10
+	vodLookupDelay = time.Minute
+)
+
+// FindVOD returns the archived VOD of a stream, or nil if there is none (yet)
+func FindVOD(ctx context.Context, username string, streamID string) (*common.ChannelLive, error) {
+	var data struct {
+		User *struct {
+			ProfileImageURL string `json:"profileImageURL"`
+			Videos          struct {
+				Edges []struct {
+					Node struct {
+						ID                  string `json:"id"`
+						Title               string `json:"title"`
+						PreviewThumbnailURL string `json:"previewThumbnailURL"`
+						BroadcastIdentifier struct {
+							ID string `json:"id"`
+						} `json:"broadcastIdentifier"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"videos"`
+		} `json:"user"`
+	}
+	if err := gqlRequest(ctx, videosQuery, map[string]any{"login": username}, &data); err != nil {
+		return nil, err
+	}
+	if data.User == nil {
+		return nil, nil
+	}
+
+	for _, edge := range data.User.Videos.Edges {
+		video := edge.Node
+		if video.BroadcastIdentifier.ID != streamID {
+			continue
+		}
+		return &common.ChannelLive{
+			Title:          video.Title,
+			ThumbnailUrl:   video.PreviewThumbnailURL,
+			ChannelID:      username,
+			VideoID:        video.ID,
+			ChannelName:    username,
+			ChannelPicture: data.User.ProfileImageURL,
+			DateCrawled:    time.Now().UTC().Format(time.RFC3339Nano),
+			Provider:       "twitch",
+			VOD:            true,
+			ParentID:       streamID,
+		}, nil
+	}
+	return nil, nil
+}
+
+// streamEnded downloads the VOD of a recorded stream once it is published,
+// if the channel asks for it
+func streamEnded(ctx context.Context, channel provider.Channel, streamID string) {
+	options, ok := channel.Options.(config.TwitchChannel)
+	if !ok || !options.DownloadVOD {
+		return
+	}
+
+	golog.Info("[twitch] ", channel.Name, " ended, looking for the VOD of stream ", streamID)
+	for lookup := 0; lookup < vodLookups; lookup++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(vodLookupDelay):
+		}
+
+		vod, err := FindVOD(ctx, channel.Name, streamID)
+		if err != nil {
+			golog.Warn("[twitch] failed to look up the VOD of ", channel.Name, ": ", err)
+			continue
+		}
+		if vod == nil {
+			continue
+		}
+		if common.IsVideoIDInDownloadJobs(vod.VideoID) {
+			golog.Debug("[twitch] VOD is already in download jobs: ", vod.VideoID)
+			return
+		}
+		golog.Info("[twitch] downloading VOD ", vod.VideoID, " of stream ", streamID)
+		provider.Record(ctx, Provider{}, vod, channel)
+		return
+	}
+	golog.Warn("[twitch] no VOD found for stream ", streamID, " of ", channel.Name)
+}
@@ -0,0 +1,316 @@
+package youtube
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"streamwatcher/common"
+	"strings"
+	"time"
+)
+
+// The structs below only hold the parts of ytInitialData and
+// ytInitialPlayerResponse that are needed to find live streams
+
+type thumbnail struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type thumbnails struct {
+	Thumbnails []thumbnail `json:"thumbnails"`
+}
+
+// largest returns the url of the widest thumbnail
+func (t thumbnails) largest() string {
+	var best thumbnail
+	for _, thumb := range t.Thumbnails {
+		if thumb.Width >= best.Width {
+			best = thumb
+		}
+	}
+	return best.URL
+}
+
+type text struct {
+	SimpleText string `json:"simpleText"`
+	Runs       []struct {
+		Text string `json:"text"`
+	} `json:"runs"`
+}
+
+func (t text) String() string {
+	if t.SimpleText != "" {
+		return t.SimpleText
+	}
+	var sb strings.Builder
+	for _, run := range t.Runs {
+		sb.WriteString(run.Text)
+	}
+	return sb.String()
+}
+
+type badge struct {
+	MetadataBadgeRenderer struct {
+		Style string `json:"style"`
+		Label string `json:"label"`
+	} `json:"metadataBadgeRenderer"`
+}
+
+type videoRenderer struct {
+	VideoID           string  `json:"videoId"`
+	Title             text    `json:"title"`
+	Badges            []badge `json:"badges"`
+	ThumbnailOverlays []struct {
+		ThumbnailOverlayTimeStatusRenderer struct {
+			Style string `json:"style"`
+		} `json:"thumbnailOverlayTimeStatusRenderer"`
+	} `json:"thumbnailOverlays"`
+	UpcomingEventData *struct {
+		StartTime string `json:"startTime"`
+	} `json:"upcomingEventData"`
+}
+
+func (v videoRenderer) hasBadge(style string) bool {
+	for _, b := range v.Badges {
+		if b.MetadataBadgeRenderer.Style == style {
+			return true
+		}
+	}
+	return false
+}
+
+func (v videoRenderer) hasOverlay(style string) bool {
+	for _, overlay := range v.ThumbnailOverlays {
+		if overlay.ThumbnailOverlayTimeStatusRenderer.Style == style {
+			return true
+		}
+	}
+	return false
+}
+
+func (v videoRenderer) isLive() bool {
+	return v.hasBadge("BADGE_STYLE_TYPE_LIVE_NOW") || v.hasOverlay("LIVE")
+}
+
+func (v videoRenderer) isUpcoming() bool {
+	return v.UpcomingEventData != nil || v.hasOverlay("UPCOMING")
+}
+
+// scheduledStart returns the announced start of an upcoming stream
+func (v videoRenderer) scheduledStart() time.Time {
+	if v.UpcomingEventData == nil {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(v.UpcomingEventData.StartTime, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
+
+func (v videoRenderer) isMembersOnly() bool {
+	return v.hasBadge("BADGE_STYLE_TYPE_MEMBERS_ONLY")
+}
+
+type gridItem struct {
+	RichItemRenderer struct {
+		Content struct {
+			VideoRenderer *videoRenderer `json:"videoRenderer"`
+		} `json:"content"`
+	} `json:"richItemRenderer"`
+}
+
+type channelInitialData struct {
+	Contents struct {
+		TwoColumnBrowseResultsRenderer struct {
+			Tabs []struct {
+				TabRenderer struct {
+					Content struct {
+						RichGridRenderer struct {
+							Contents []gridItem `json:"contents"`
+						} `json:"richGridRenderer"`
+					} `json:"content"`
+				} `json:"tabRenderer"`
+			} `json:"tabs"`
+		} `json:"twoColumnBrowseResultsRenderer"`
+	} `json:"contents"`
+	Metadata struct {
+		ChannelMetadataRenderer struct {
+			Title      string     `json:"title"`
+			ExternalID string     `json:"externalId"`
+			Avatar     thumbnails `json:"avatar"`
+		} `json:"channelMetadataRenderer"`
+	} `json:"metadata"`
+}
+
+func (d channelInitialData) videos() []videoRenderer {
+	var videos []videoRenderer
+	for _, tab := range d.Contents.TwoColumnBrowseResultsRenderer.Tabs {
+		for _, item := range tab.TabRenderer.Content.RichGridRenderer.Contents {
+			if v := item.RichItemRenderer.Content.VideoRenderer; v != nil {
+				videos = append(videos, *v)
+			}
+		}
+	}
+	return videos
+}
+
+type playerResponse struct {
+	VideoDetails struct {
+		VideoID       string `json:"videoId"`
+		Title         string `json:"title"`
+		ChannelID     string `json:"channelId"`
+		Author        string `json:"author"`
+		IsLive        bool   `json:"isLive"`
+		IsUpcoming    bool   `json:"isUpcoming"`
+		IsLiveContent bool   `json:"isLiveContent"`
+	} `json:"videoDetails"`
+	Microformat struct {
+		PlayerMicroformatRenderer struct {
+			LiveBroadcastDetails *struct {
+				IsLiveNow      bool   `json:"isLiveNow"`
+				StartTimestamp string `json:"startTimestamp"`
+				EndTimestamp   string `json:"endTimestamp"`
+			} `json:"liveBroadcastDetails"`
+		} `json:"playerMicroformatRenderer"`
+	} `json:"microformat"`
+}
+
+type watchInitialData struct {
+	Contents struct {
+		TwoColumnWatchNextResults struct {
+			Results struct {
+				Results struct {
+					Contents []struct {
+						VideoPrimaryInfoRenderer *struct {
+							Badges []badge `json:"badges"`
+						} `json:"videoPrimaryInfoRenderer"`
+						VideoSecondaryInfoRenderer *struct {
+							Owner struct {
+								VideoOwnerRenderer struct {
+									Thumbnail thumbnails `json:"thumbnail"`
+								} `json:"videoOwnerRenderer"`
+							} `json:"owner"`
+						} `json:"videoSecondaryInfoRenderer"`
+					} `json:"contents"`
+				} `json:"results"`
+			} `json:"results"`
+		} `json:"twoColumnWatchNextResults"`
+	} `json:"contents"`
+}
+
+func (d watchInitialData) channelPicture() string {
+	for _, content := range d.Contents.TwoColumnWatchNextResults.Results.Results.Contents {
+		if info := content.VideoSecondaryInfoRenderer; info != nil {
+			return info.Owner.VideoOwnerRenderer.Thumbnail.largest()
+		}
+	}
+	return ""
+}
+
+func (d watchInitialData) isMembersOnly() bool {
+	for _, content := range d.Contents.TwoColumnWatchNextResults.Results.Results.Contents {
+		if info := content.VideoPrimaryInfoRenderer; info != nil {
+			for _, b := range info.Badges {
+				if b.MetadataBadgeRenderer.Style == "BADGE_STYLE_TYPE_MEMBERS_ONLY" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// extractJSON decodes the object assigned to name in the page, e.g.
+// `var ytInitialData = {...};`
+func extractJSON(body []byte, name string, v any) error {
+	for _, marker := range []string{"var " + name + " = ", "window[\"" + name + "\"] = ", name + " = "} {
+		idx := bytes.Index(body, []byte(marker))
+		if idx == -1 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(body[idx+len(marker):]))
+		if err := decoder.Decode(v); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no %s found", name)
+}
+
+// parseStreamsPage returns every live and upcoming stream listed on a /streams page
+func parseStreamsPage(channelID string, body []byte) ([]*common.ChannelLive, error) {
+	var data channelInitialData
+	if err := extractJSON(body, "ytInitialData", &data); err != nil {
+		return nil, err
+	}
+
+	metadata := data.Metadata.ChannelMetadataRenderer
+	dateCrawled := time.Now().UTC().Format(time.RFC3339Nano)
+	var lives []*common.ChannelLive
+	for _, video := range data.videos() {
+		upcoming := !video.isLive() && video.isUpcoming()
+		if !video.isLive() && !upcoming {
+			continue
+		}
+		lives = append(lives, &common.ChannelLive{
+			Title:          video.Title.String(),
+			ChannelID:      channelID,
+			ThumbnailUrl:   fmt.Sprintf("https://img.youtube.com/vi/%s/0.jpg", video.VideoID),
+			VideoID:        video.VideoID,
+			ChannelName:    metadata.Title,
+			ChannelPicture: metadata.Avatar.largest(),
+			DateCrawled:    dateCrawled,
+			MembersOnly:    video.isMembersOnly(),
+			Provider:       "youtube",
+			Upcoming:       upcoming,
+			ScheduledStart: video.scheduledStart(),
+		})
+	}
+	return lives, nil
+}
+
+// parseWatchPage returns the details of the video on a /watch page and
+// whether it is live right now
+func parseWatchPage(videoID string, body []byte) (*common.ChannelLive, bool, error) {
+	var player playerResponse
+	if err := extractJSON(body, "ytInitialPlayerResponse", &player); err != nil {
+		return nil, false, err
+	}
+	details := player.VideoDetails
+	if details.Title == "" {
+		return nil, false, fmt.Errorf("no title found")
+	}
+	if details.ChannelID == "" {
+		return nil, false, fmt.Errorf("no channel id found")
+	}
+
+	// The initial data only adds the channel picture and badges, the video
+	// details are still usable without it
+	var data watchInitialData
+	_ = extractJSON(body, "ytInitialData", &data)
+
+	isLive := details.IsLive
+	var scheduledStart time.Time
+	if broadcast := player.Microformat.PlayerMicroformatRenderer.LiveBroadcastDetails; broadcast != nil {
+		scheduledStart, _ = time.Parse(time.RFC3339, broadcast.StartTimestamp)
+		isLive = isLive || broadcast.IsLiveNow
+	}
+
+	return &common.ChannelLive{
+		Title:          details.Title,
+		ChannelID:      details.ChannelID,
+		ThumbnailUrl:   fmt.Sprintf("https://img.youtube.com/vi/%s/0.jpg", videoID),
+		VideoID:        videoID,
+		ChannelName:    details.Author,
+		ChannelPicture: data.channelPicture(),
+		DateCrawled:    time.Now().UTC().Format(time.RFC3339Nano),
+		MembersOnly:    data.isMembersOnly(),
+		Provider:       "youtube",
+		Upcoming:       details.IsUpcoming,
+		ScheduledStart: scheduledStart,
+	}, isLive, nil
+}
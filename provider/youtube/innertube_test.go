@@ -0,0 +1,127 @@
+package youtube
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestParseStreamsPage(t *testing.T) {
+	lives, err := parseStreamsPage("UCexample0000000000000000", readFixture(t, "streams.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		videoID        string
+		title          string
+		membersOnly    bool
+		upcoming       bool
+		scheduledStart time.Time
+	}{
+		{"live0000001", `【Karaoke】 Songs & chat 🎤 "encore"`, false, false, time.Time{}},
+		{"live0000002", "Members stream <3", true, false, time.Time{}},
+		{"upcoming001", "Minecraft — day 3", false, true, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if len(lives) != len(want) {
+		t.Fatalf("got %d lives, want %d", len(lives), len(want))
+	}
+	for i, w := range want {
+		live := lives[i]
+		if live.VideoID != w.videoID {
+			t.Errorf("lives[%d].VideoID = %q, want %q", i, live.VideoID, w.videoID)
+		}
+		if live.Title != w.title {
+			t.Errorf("%s: Title = %q, want %q", w.videoID, live.Title, w.title)
+		}
+		if live.MembersOnly != w.membersOnly {
+			t.Errorf("%s: MembersOnly = %v, want %v", w.videoID, live.MembersOnly, w.membersOnly)
+		}
+		if live.Upcoming != w.upcoming {
+			t.Errorf("%s: Upcoming = %v, want %v", w.videoID, live.Upcoming, w.upcoming)
+		}
+		if !live.ScheduledStart.Equal(w.scheduledStart) {
+			t.Errorf("%s: ScheduledStart = %v, want %v", w.videoID, live.ScheduledStart, w.scheduledStart)
+		}
+		if live.ChannelID != "UCexample0000000000000000" || live.ChannelName != "Example Channel" {
+			t.Errorf("%s: channel = %q %q", w.videoID, live.ChannelID, live.ChannelName)
+		}
+		if live.ChannelPicture != "https://yt3.example/avatar=s900" {
+			t.Errorf("%s: ChannelPicture = %q, want the widest avatar", w.videoID, live.ChannelPicture)
+		}
+	}
+}
+
+func TestParseStreamsPageWithoutData(t *testing.T) {
+	if _, err := parseStreamsPage("UCexample0000000000000000", []byte("<html><body>consent</body></html>")); err == nil {
+		t.Fatal("expected an error for a page without ytInitialData")
+	}
+}
+
+func TestParseWatchPage(t *testing.T) {
+	tests := []struct {
+		fixture        string
+		videoID        string
+		title          string
+		isLive         bool
+		upcoming       bool
+		membersOnly    bool
+		scheduledStart time.Time
+		channelPicture string
+	}{
+		{
+			fixture:        "watch_live.html",
+			videoID:        "live0000001",
+			title:          `【Karaoke】 Songs & chat 🎤 "encore"`,
+			isLive:         true,
+			scheduledStart: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			channelPicture: "https://yt3.example/owner=s176",
+		},
+		{
+			fixture:        "watch_upcoming.html",
+			videoID:        "upcoming002",
+			title:          "Members only watchalong",
+			upcoming:       true,
+			membersOnly:    true,
+			scheduledStart: time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			live, isLive, err := parseWatchPage(tt.videoID, readFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isLive != tt.isLive {
+				t.Errorf("isLive = %v, want %v", isLive, tt.isLive)
+			}
+			if live.Title != tt.title {
+				t.Errorf("Title = %q, want %q", live.Title, tt.title)
+			}
+			if live.ChannelID != "UCexample0000000000000000" || live.ChannelName != "Example Channel" {
+				t.Errorf("channel = %q %q", live.ChannelID, live.ChannelName)
+			}
+			if live.Upcoming != tt.upcoming {
+				t.Errorf("Upcoming = %v, want %v", live.Upcoming, tt.upcoming)
+			}
+			if live.MembersOnly != tt.membersOnly {
+				t.Errorf("MembersOnly = %v, want %v", live.MembersOnly, tt.membersOnly)
+			}
+			if !live.ScheduledStart.Equal(tt.scheduledStart) {
+				t.Errorf("ScheduledStart = %v, want %v", live.ScheduledStart, tt.scheduledStart)
+			}
+			if live.ChannelPicture != tt.channelPicture {
+				t.Errorf("ChannelPicture = %q, want %q", live.ChannelPicture, tt.channelPicture)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package youtube
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"streamwatcher/common"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+type feed struct {
+	Entries []feedEntry `xml:"entry"`
+}
+
+type feedEntry struct {
+	VideoID   string    `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	ChannelID string    `xml:"http://www.youtube.com/xml/schemas/2015 channelId"`
+	Title     string    `xml:"title"`
+	Published time.Time `xml:"published"`
+	Updated   time.Time `xml:"updated"`
+}
+
+var (
+	// seenVideos holds, per channel, the feed entries that are known not to
+	// need another check
+	seenVideos     = make(map[string]map[string]bool)
+	seenVideosLock sync.Mutex
+)
+
+func fetchFeed(ctx context.Context, channelID string) (*feed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/feeds/videos.xml?channel_id=%s", baseURL, channelID), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("[youtube] unexpected status %d for feed of %s", resp.StatusCode, channelID)
+	}
+
+	var result feed
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("[youtube] failed to decode feed of %s: %w", channelID, err)
+	}
+	return &result, nil
+}
+
+// checkFeed polls the RSS feed of a channel and only fetches the watch page of
+// videos it hasn't seen yet, returning those that are live or upcoming. Live
+// and upcoming videos are only seen once they ended.
+func checkFeed(ctx context.Context, channelID string, useMemberCookies bool) ([]*common.ChannelLive, error) {
+	result, err := fetchFeed(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	seenVideosLock.Lock()
+	seen, polledBefore := seenVideos[channelID]
+	seenVideosLock.Unlock()
+
+	current := make(map[string]bool)
+	var lives []*common.ChannelLive
+	for _, entry := range result.Entries {
+		if seen[entry.VideoID] {
+			current[entry.VideoID] = true
+			continue
+		}
+		// On the first poll only look at recent videos, the older ones are
+		// not going to be live anymore
+		if !polledBefore && time.Since(entry.Published) > 24*time.Hour && time.Since(entry.Updated) > 24*time.Hour {
+			current[entry.VideoID] = true
+			continue
+		}
+
+		golog.Debug("[youtube] new feed entry for ", channelID, ": ", entry.VideoID)
+		live, isLive, err := getVideoDetails(ctx, entry.VideoID, useMemberCookies)
+		if err != nil {
+			golog.Warn("[youtube] failed to check feed entry ", entry.VideoID, ": ", err)
+			continue
+		}
+		switch {
+		case isLive:
+			// Check it again on the next poll until it ends, so a recording
+			// that failed is retried
+			live.Upcoming = false
+			lives = append(lives, live)
+		case live.Upcoming:
+			// Check it again on the next poll until it goes live
+			lives = append(lives, live)
+		default:
+			current[entry.VideoID] = true
+		}
+	}
+
+	seenVideosLock.Lock()
+	seenVideos[channelID] = current
+	seenVideosLock.Unlock()
+	return lives, nil
+}
@@ -0,0 +1,102 @@
+package youtube
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// serveYouTube serves the feed and watch page fixtures in place of youtube.com.
+// The returned func changes the watch page fixture of a video.
+func serveYouTube(t *testing.T) func(videoID string, fixture string) {
+	t.Helper()
+	feed := template.Must(template.ParseFiles("testdata/feed.xml"))
+	var watchPagesLock sync.Mutex
+	watchPages := map[string]string{
+		"live0000001": "watch_live.html",
+		"upcoming002": "watch_upcoming.html",
+		"vod00000001": "watch_vod.html",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feeds/videos.xml", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("channel_id") != "UCexample0000000000000000" {
+			http.NotFound(w, r)
+			return
+		}
+		feed.Execute(w, map[string]string{
+			"Recent": time.Now().Add(-time.Hour).Format(time.RFC3339),
+			"Old":    time.Now().Add(-7 * 24 * time.Hour).Format(time.RFC3339),
+		})
+	})
+	mux.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		watchPagesLock.Lock()
+		fixture, exists := watchPages[r.URL.Query().Get("v")]
+		watchPagesLock.Unlock()
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(readFixture(t, fixture))
+	})
+	server := httptest.NewServer(mux)
+
+	previous := baseURL
+	baseURL = server.URL
+	t.Cleanup(func() {
+		baseURL = previous
+		server.Close()
+	})
+	return func(videoID string, fixture string) {
+		watchPagesLock.Lock()
+		watchPages[videoID] = fixture
+		watchPagesLock.Unlock()
+	}
+}
+
+func TestCheckFeed(t *testing.T) {
+	setWatchPage := serveYouTube(t)
+	seenVideos = make(map[string]map[string]bool)
+
+	check := func() []string {
+		t.Helper()
+		lives, err := checkFeed(context.Background(), "UCexample0000000000000000", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, live := range lives {
+			ids = append(ids, live.VideoID)
+		}
+		return ids
+	}
+
+	// The first poll skips the VOD and the entry older than 24 hours
+	if ids := check(); len(ids) != 2 || ids[0] != "live0000001" || ids[1] != "upcoming002" {
+		t.Fatalf("first poll = %v, want the live and the upcoming stream", ids)
+	}
+	// Later polls skip the seen entries, but check the live and upcoming
+	// ones again until they end
+	if ids := check(); len(ids) != 2 || ids[0] != "live0000001" || ids[1] != "upcoming002" {
+		t.Fatalf("second poll = %v, want the live and the upcoming stream", ids)
+	}
+	setWatchPage("live0000001", "watch_vod.html")
+	if ids := check(); len(ids) != 1 || ids[0] != "upcoming002" {
+		t.Fatalf("third poll = %v, want the upcoming stream only", ids)
+	}
+	setWatchPage("live0000001", "watch_live.html")
+	if ids := check(); len(ids) != 1 || ids[0] != "upcoming002" {
+		t.Fatalf("fourth poll = %v, want the ended stream to stay seen", ids)
+	}
+}
+
+func TestCheckFeedUnknownChannel(t *testing.T) {
+	serveYouTube(t)
+	if _, err := checkFeed(context.Background(), "UCunknown", false); err == nil {
+		t.Fatal("expected an error for a missing feed")
+	}
+}
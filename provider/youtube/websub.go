@@ -0,0 +1,262 @@
+package youtube
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"streamwatcher/config"
+	"streamwatcher/provider"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kataras/golog"
+)
+
+const topicPrefix = "https://www.youtube.com/xml/feeds/videos.xml?channel_id="
+
+var (
+	// leases holds when the subscription of each channel expires
+	leases = make(map[string]time.Time)
+	// pending holds the lease asked for in the subscriptions the hub has yet
+	// to verify. Only those are verified, anyone can call the callback.
+	pending    = make(map[string]int)
+	leasesLock sync.Mutex
+	webSubCtx  = context.Background()
+)
+
+// StartWebSub subscribes every configured channel to the WebSub hub and renews
+// the subscriptions before their lease expires, until ctx is done
+func StartWebSub(ctx context.Context) {
+	webSubCtx = ctx
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		renewSubscriptions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func renewSubscriptions(ctx context.Context) {
+	leaseSeconds := webSubLeaseSeconds()
+	for _, channel := range config.Get().YouTubeChannel {
+		leasesLock.Lock()
+		expiry, subscribed := leases[channel.ID]
+		leasesLock.Unlock()
+
+		// Renew once less than a tenth of the lease is left
+		margin := time.Duration(leaseSeconds) * time.Second / 10
+		if subscribed && time.Until(expiry) > margin {
+			continue
+		}
+		if err := subscribe(ctx, channel.ID, leaseSeconds); err != nil {
+			golog.Warn("[websub] failed to subscribe ", channel.Name, ": ", err)
+			continue
+		}
+		// Until the hub verifies the subscription, retry after the margin
+		leasesLock.Lock()
+		if retry := time.Now().Add(2 * margin); leases[channel.ID].Before(retry) {
+			leases[channel.ID] = retry
+		}
+		leasesLock.Unlock()
+	}
+}
+
+func subscribe(ctx context.Context, channelID string, leaseSeconds int) (err error) {
+	// The hub may verify before it answered this request
+	leasesLock.Lock()
+	pending[channelID] = leaseSeconds
+	leasesLock.Unlock()
+	defer func() {
+		if err != nil {
+			leasesLock.Lock()
+			delete(pending, channelID)
+			leasesLock.Unlock()
+		}
+	}()
+
+	form := url.Values{
+		"hub.callback":      {config.Get().WebSub.CallbackURL},
+		"hub.topic":         {topicPrefix + channelID},
+		"hub.mode":          {"subscribe"},
+		"hub.verify":        {"async"},
+		"hub.lease_seconds": {strconv.Itoa(leaseSeconds)},
+		"hub.secret":        {config.Get().WebSub.Secret},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webSubHubURL(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("hub returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	golog.Debug("[websub] subscription requested for ", channelID)
+	return nil
+}
+
+// WebSubHandler answers the hub's verification requests and handles the
+// notifications it pushes
+func WebSubHandler(w http.ResponseWriter, r *http.Request) {
+	if !config.Get().WebSub.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		verifySubscription(w, r)
+	case http.MethodPost:
+		receiveNotification(w, r)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func verifySubscription(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode := query.Get("hub.mode")
+	channelID := strings.TrimPrefix(query.Get("hub.topic"), topicPrefix)
+
+	if mode == "denied" {
+		golog.Warn("[websub] subscription denied for ", channelID, ": ", query.Get("hub.reason"))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only subscriptions are requested, never unsubscriptions
+	leasesLock.Lock()
+	requested, exists := pending[channelID]
+	if mode != "subscribe" || !exists || !isConfiguredChannel(channelID) {
+		leasesLock.Unlock()
+		golog.Warn("[websub] ignoring unrequested ", mode, " verification for ", channelID)
+		http.Error(w, "Unknown topic", http.StatusNotFound)
+		return
+	}
+	delete(pending, channelID)
+	leaseSeconds, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+	if err != nil || leaseSeconds > requested {
+		leaseSeconds = requested
+	}
+	leases[channelID] = time.Now().Add(time.Duration(leaseSeconds) * time.Second)
+	leasesLock.Unlock()
+	golog.Info("[websub] subscribed to ", channelID, " for ", leaseSeconds, " seconds")
+
+	w.Write([]byte(query.Get("hub.challenge")))
+}
+
+func receiveNotification(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	// The hub expects a 2xx even for notifications that get ignored
+	w.WriteHeader(http.StatusNoContent)
+
+	// Unsigned notifications are ignored, anyone could send them
+	if secret := config.Get().WebSub.Secret; secret == "" || !validSignature(secret, body, r.Header.Get("X-Hub-Signature")) {
+		golog.Warn("[websub] ignoring notification with an invalid signature")
+		return
+	}
+
+	var notification feed
+	if err := xml.Unmarshal(body, &notification); err != nil {
+		golog.Warn("[websub] failed to decode notification: ", err)
+		return
+	}
+	for _, entry := range notification.Entries {
+		golog.Info("[websub] notification for ", entry.ChannelID, ": ", entry.VideoID)
+		go checkNotifiedVideo(webSubCtx, entry.ChannelID, entry.VideoID)
+	}
+}
+
+// validSignature checks the X-Hub-Signature header, "sha1=<hex hmac of body>"
+func validSignature(secret string, body []byte, header string) bool {
+	algorithm, signature, found := strings.Cut(header, "=")
+	if !found || algorithm != "sha1" {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// checkNotifiedVideo records a pushed video right away when it is live or
+// about to start
+func checkNotifiedVideo(ctx context.Context, channelID string, videoID string) {
+	var p Provider
+	channel := provider.ChannelFor(p, channelID)
+	options, configured := channel.Options.(config.YouTubeChannel)
+	if !configured {
+		golog.Debug("[websub] notification for unknown channel: ", channelID)
+		return
+	}
+
+	live, isLive, err := getVideoDetails(ctx, videoID, options.UseMemberCookies)
+	if err != nil {
+		golog.Warn("[websub] failed to check ", videoID, ": ", err)
+		return
+	}
+	if live.ChannelID != channelID {
+		golog.Warn("[websub] ignoring ", videoID, ", it belongs to ", live.ChannelID, " not ", channelID)
+		return
+	}
+	if !isLive && !live.Upcoming {
+		golog.Debug("[websub] ", videoID, " is not live or upcoming")
+		return
+	}
+	if isLive {
+		live.Upcoming = false
+	}
+	if p.ShouldRecord(live, channel) {
+		provider.Record(ctx, p, live, channel)
+	}
+}
+
+func isConfiguredChannel(channelID string) bool {
+	for _, channel := range config.Get().YouTubeChannel {
+		if channel.ID == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+func webSubHubURL() string {
+	if config.Get().WebSub.HubURL != "" {
+		return config.Get().WebSub.HubURL
+	}
+	return "https://pubsubhubbub.appspot.com/subscribe"
+}
+
+func webSubLeaseSeconds() int {
+	if config.Get().WebSub.LeaseSeconds > 0 {
+		return config.Get().WebSub.LeaseSeconds
+	}
+	return 432000
+}
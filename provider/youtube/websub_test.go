@@ -0,0 +1,209 @@
+package youtube
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"streamwatcher/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setWebSubConfig(t *testing.T, hubURL string) {
+	t.Helper()
+	previous := config.Get()
+	config.Set(&config.Config{
+		YouTubeChannel: []config.YouTubeChannel{
+			{ID: "UCexample0000000000000000", Name: "Example Channel"},
+			{ID: "UCother00000000000000000", Name: "Other Channel"},
+		},
+		WebSub: config.WebSubConfig{
+			Enabled:      true,
+			CallbackURL:  "https://watcher.example/api/websub/youtube",
+			Secret:       "hub-secret",
+			HubURL:       hubURL,
+			LeaseSeconds: 3600,
+		},
+	})
+	leasesLock.Lock()
+	leases = make(map[string]time.Time)
+	pending = make(map[string]int)
+	leasesLock.Unlock()
+	t.Cleanup(func() { config.Set(previous) })
+}
+
+func sign(secret string, body string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestValidSignature(t *testing.T) {
+	body := `<feed><entry></entry></feed>`
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"valid", sign("hub-secret", body), true},
+		{"other secret", sign("other", body), false},
+		{"other algorithm", strings.Replace(sign("hub-secret", body), "sha1=", "sha256=", 1), false},
+		{"not hex", "sha1=zz", false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSignature("hub-secret", []byte(body), tt.header); got != tt.want {
+				t.Errorf("validSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySubscription(t *testing.T) {
+	setWebSubConfig(t, "")
+
+	verify := func(mode string, channelID string, leaseSeconds string) *httptest.ResponseRecorder {
+		query := url.Values{
+			"hub.mode":          {mode},
+			"hub.topic":         {topicPrefix + channelID},
+			"hub.challenge":     {"challenge-123"},
+			"hub.lease_seconds": {leaseSeconds},
+		}
+		recorder := httptest.NewRecorder()
+		WebSubHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/websub/youtube?"+query.Encode(), nil))
+		return recorder
+	}
+	leaseUntil := func(channelID string) time.Duration {
+		leasesLock.Lock()
+		defer leasesLock.Unlock()
+		return time.Until(leases[channelID])
+	}
+
+	// Nothing was requested yet, so nothing is verified
+	for _, mode := range []string{"subscribe", "unsubscribe"} {
+		if recorder := verify(mode, "UCexample0000000000000000", "999999999"); recorder.Code != http.StatusNotFound || recorder.Body.String() == "challenge-123" {
+			t.Fatalf("unrequested %s: got %d %q, want 404", mode, recorder.Code, recorder.Body.String())
+		}
+	}
+	if until := leaseUntil("UCexample0000000000000000"); until > 0 {
+		t.Fatalf("unrequested verification set a lease of %v", until)
+	}
+
+	leasesLock.Lock()
+	pending["UCexample0000000000000000"] = 3600
+	pending["UCother00000000000000000"] = 3600
+	leasesLock.Unlock()
+
+	if recorder := verify("unsubscribe", "UCexample0000000000000000", ""); recorder.Code != http.StatusNotFound {
+		t.Fatalf("unsubscribe: got %d, want 404 as it was never requested", recorder.Code)
+	}
+	if recorder := verify("subscribe", "UCunknown", "600"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("unknown topic: got %d, want 404", recorder.Code)
+	}
+
+	recorder := verify("subscribe", "UCexample0000000000000000", "600")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "challenge-123" {
+		t.Fatalf("subscribe: got %d %q, want the challenge echoed", recorder.Code, recorder.Body.String())
+	}
+	if until := leaseUntil("UCexample0000000000000000"); until < 590*time.Second || until > 600*time.Second {
+		t.Fatalf("lease expires in %v, want the 600 seconds of the hub", until)
+	}
+	// The request is answered once
+	if recorder := verify("subscribe", "UCexample0000000000000000", "600"); recorder.Code != http.StatusNotFound {
+		t.Fatalf("second verification: got %d, want 404", recorder.Code)
+	}
+
+	// A longer lease than the one asked for is capped
+	if recorder := verify("subscribe", "UCother00000000000000000", "999999999"); recorder.Code != http.StatusOK {
+		t.Fatalf("subscribe: got %d", recorder.Code)
+	}
+	if until := leaseUntil("UCother00000000000000000"); until > time.Hour {
+		t.Fatalf("lease expires in %v, want at most the hour asked for", until)
+	}
+}
+
+func TestReceiveNotificationNeedsSignature(t *testing.T) {
+	setWebSubConfig(t, "")
+	fetched := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched <- r.URL.String()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	previous := baseURL
+	baseURL = server.URL
+	defer func() { baseURL = previous }()
+
+	body := `<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom"><entry><yt:videoId>live0000001</yt:videoId><yt:channelId>UCexample0000000000000000</yt:channelId></entry></feed>`
+	for _, signature := range []string{"", sign("other", body)} {
+		req := httptest.NewRequest(http.MethodPost, "/api/websub/youtube", strings.NewReader(body))
+		if signature != "" {
+			req.Header.Set("X-Hub-Signature", signature)
+		}
+		recorder := httptest.NewRecorder()
+		WebSubHandler(recorder, req)
+		if recorder.Code != http.StatusNoContent {
+			t.Fatalf("got %d, want 204 so the hub doesn't retry", recorder.Code)
+		}
+	}
+
+	select {
+	case page := <-fetched:
+		t.Fatalf("unsigned notification fetched %s", page)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRenewSubscriptions(t *testing.T) {
+	requests := make(chan url.Values, 4)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests <- r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+	setWebSubConfig(t, hub.URL)
+
+	// One lease is still good, the other expires within the renewal margin
+	leasesLock.Lock()
+	leases["UCexample0000000000000000"] = time.Now().Add(time.Hour)
+	leases["UCother00000000000000000"] = time.Now().Add(time.Minute)
+	leasesLock.Unlock()
+
+	renewSubscriptions(context.Background())
+
+	if len(requests) != 1 {
+		t.Fatalf("got %d subscription requests, want 1", len(requests))
+	}
+	form := <-requests
+	want := map[string]string{
+		"hub.mode":          "subscribe",
+		"hub.topic":         topicPrefix + "UCother00000000000000000",
+		"hub.callback":      "https://watcher.example/api/websub/youtube",
+		"hub.secret":        "hub-secret",
+		"hub.lease_seconds": "3600",
+	}
+	for key, value := range want {
+		if form.Get(key) != value {
+			t.Errorf("%s = %q, want %q", key, form.Get(key), value)
+		}
+	}
+
+	// Until the hub verifies, the renewal is retried after the margin
+	leasesLock.Lock()
+	retry := time.Until(leases["UCother00000000000000000"])
+	requested := pending["UCother00000000000000000"]
+	leasesLock.Unlock()
+	if requested != 3600 {
+		t.Errorf("pending lease = %d, want the 3600 seconds asked for", requested)
+	}
+	if retry < 11*time.Minute || retry > 12*time.Minute {
+		t.Errorf("retry in %v, want twice the 6 minute margin", retry)
+	}
+}
@@ -1,17 +1,17 @@
 package youtube
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
-	"regexp"
 	"streamwatcher/common"
 	"streamwatcher/config"
-	"streamwatcher/helpers/discord"
-	"streamwatcher/helpers/ytarchive"
+	"streamwatcher/helpers/downloader"
+	"streamwatcher/provider"
 
 	"strings"
 	"time"
@@ -73,7 +73,10 @@ func ParseNetscapeCookieFile(filepath string) ([]*http.Cookie, error) {
 	return cookies, nil
 }
 
-func GetChannelLive(channelID string, useMemberCookies bool) (*common.ChannelLive, error) {
+var baseURL = "https://www.youtube.com"
+
+// newClient returns an http client carrying the configured YouTube cookies
+func newClient(useMemberCookies bool) (*http.Client, error) {
 	// Create a cookie jar
 	jar, err := cookiejar.New(nil)
 	if err != nil {
@@ -81,15 +84,9 @@ func GetChannelLive(channelID string, useMemberCookies bool) (*common.ChannelLiv
 	}
 
 	// Parse and set cookies if file path is provided
-	var cookieFilePath string
-	if config.AppConfig.Archive.Cookies != "" {
-		if useMemberCookies {
-			cookieFilePath = config.AppConfig.Archive.MemberCookies
-		} else {
-			cookieFilePath = config.AppConfig.Archive.Cookies
-		}
-	} else {
-		cookieFilePath = ""
+	cookieFilePath := config.Get().Archive.Cookies
+	if cookieFilePath != "" && useMemberCookies {
+		cookieFilePath = config.Get().Archive.MemberCookies
 	}
 	if cookieFilePath != "" {
 		cookies, err := ParseNetscapeCookieFile(cookieFilePath)
@@ -97,176 +94,157 @@ func GetChannelLive(channelID string, useMemberCookies bool) (*common.ChannelLiv
 			return nil, fmt.Errorf("failed to parse cookie file: %v", err)
 		}
 
-		ytUrl, _ := url.Parse("https://www.youtube.com")
+		ytUrl, _ := url.Parse(baseURL)
 		jar.SetCookies(ytUrl, cookies)
 	}
 
-	// Create HTTP client with cookie jar
-	client := &http.Client{
+	return &http.Client{
 		Jar: jar,
-	}
+	}, nil
+}
 
-	// Make request with cookies
-	// resp, err := client.Get(fmt.Sprintf("https://www.youtube.com/channel/%s/live", channelID))
-	resp, err := client.Get(fmt.Sprintf("https://www.youtube.com/channel/%s/streams", channelID))
+func fetchPage(ctx context.Context, client *http.Client, pageURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	// Ask for the english page, badges and overlays are matched by style but
+	// their labels are localized
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
 
-	body, err := io.ReadAll(resp.Body)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	fragments := strings.Split(string(body), "videoRenderer")
-	for _, fragment := range fragments {
-		reLive := regexp.MustCompile(`"text+":"LIVE"`)
-		if reLive.MatchString(fragment) {
-			reVideoID := regexp.MustCompile(`"videoId":"([^"]+)`)
-			videoID := reVideoID.FindStringSubmatch(fragment)
-			reMembersOnly := regexp.MustCompile(`"[a-zA-Z]+":"Members only"`)
-			isMembersOnly := false
-			if reMembersOnly.MatchString(fragment) {
-				golog.Debug("[youtube] channel is live but members only: ", channelID)
-				isMembersOnly = true
-
-			}
-			if len(videoID) < 2 {
-				return nil, fmt.Errorf("no video id found")
-			}
-			reTitle := regexp.MustCompile(`title":{"runs":\[{"text":"([^"]+)`)
-			title := reTitle.FindStringSubmatch(fragment)
-			if len(title) < 2 {
-				return nil, fmt.Errorf("no title found")
-			}
-
-			reChannelPic := regexp.MustCompile(`<meta name="twitter:image" content="(.*?)"`)
-			channelPic := reChannelPic.FindStringSubmatch(string(body))
-			if len(channelPic) < 2 {
-				return nil, fmt.Errorf("no channel pic found")
-			}
-
-			reChannelName := regexp.MustCompile(`<meta\s+property="og:title"\s+content="([^"]+)">`)
-			channelName := reChannelName.FindStringSubmatch(string(body))
-			if len(channelName) < 2 {
-				return nil, fmt.Errorf("no channel name found")
-			}
-
-			dateCrawled := time.Now().UTC().Format(time.RFC3339Nano)
-			return &common.ChannelLive{
-				Title:          title[1],
-				ChannelID:      channelID,
-				ThumbnailUrl:   fmt.Sprintf("https://img.youtube.com/vi/%s/0.jpg", videoID[1]),
-				VideoID:        videoID[1],
-				ChannelName:    channelName[1],
-				ChannelPicture: channelPic[1],
-				DateCrawled:    dateCrawled,
-				MembersOnly:    isMembersOnly,
-			}, nil
-		}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d for %s", resp.StatusCode, pageURL)
 	}
-	return nil, nil
+	return io.ReadAll(resp.Body)
 }
 
-func GetVideoDetailsFromID(videoID string) (*common.ChannelLive, error) {
-	// Create a cookie jar
-	jar, err := cookiejar.New(nil)
+// GetChannelLives returns the live streams listed on the /streams tab of a channel
+func GetChannelLives(ctx context.Context, channelID string, useMemberCookies bool) ([]*common.ChannelLive, error) {
+	client, err := newClient(useMemberCookies)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cookie jar: %v", err)
+		return nil, err
 	}
 
-	// Parse and set cookies if file path is provided
-	var cookieFilePath string
-	if config.AppConfig.Archive.Cookies != "" {
-		cookieFilePath = config.AppConfig.Archive.Cookies
-	} else {
-		cookieFilePath = ""
+	body, err := fetchPage(ctx, client, fmt.Sprintf("%s/channel/%s/streams", baseURL, channelID))
+	if err != nil {
+		return nil, err
 	}
-	if cookieFilePath != "" {
-		cookies, err := ParseNetscapeCookieFile(cookieFilePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse cookie file: %v", err)
-		}
 
-		ytUrl, _ := url.Parse("https://www.youtube.com")
-		jar.SetCookies(ytUrl, cookies)
+	lives, err := parseStreamsPage(channelID, body)
+	if err != nil {
+		return nil, fmt.Errorf("[youtube] failed to parse streams of %s: %w", channelID, err)
 	}
-
-	// Create HTTP client with cookie jar
-	client := &http.Client{
-		Jar: jar,
+	for _, live := range lives {
+		if live.MembersOnly {
+			golog.Debug("[youtube] channel is live but members only: ", channelID)
+		}
 	}
+	return lives, nil
+}
+
+func GetVideoDetailsFromID(videoID string) (*common.ChannelLive, error) {
+	live, _, err := getVideoDetails(context.Background(), videoID, false)
+	return live, err
+}
 
-	// Make request with cookies
-	resp, err := client.Get(fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoID))
+// getVideoDetails fetches the watch page of a video, also reporting whether
+// the video is live right now
+func getVideoDetails(ctx context.Context, videoID string, useMemberCookies bool) (*common.ChannelLive, bool, error) {
+	client, err := newClient(useMemberCookies)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := fetchPage(ctx, client, fmt.Sprintf("%s/watch?v=%s", baseURL, videoID))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	reTitle := regexp.MustCompile(`"videoDetails":\{"videoId":"[^"]+","title":"([^"]+)"`)
-	title := reTitle.FindStringSubmatch(string(body))
-	if len(title) < 2 {
-		return nil, fmt.Errorf("no title found")
-	}
+	return parseWatchPage(videoID, body)
+}
 
-	reChannelId := regexp.MustCompile(`"videoDetails":\{[^}]*"channelId":"([^"]+)"`)
-	channelId := reChannelId.FindStringSubmatch(string(body))
-	if len(channelId) < 2 {
-		return nil, fmt.Errorf("no channel id found")
-	}
+// waitArgs makes a downloader wait for a scheduled stream instead of failing
+var waitArgs = map[string][]string{
+	"ytarchive": {"--wait"},
+	"yt-dlp":    {"--wait-for-video", "60"},
+}
 
-	reChannelPic := regexp.MustCompile(`<meta name="twitter:image" content="(.*?)"`)
-	channelPic := reChannelPic.FindStringSubmatch(string(body))
+// Provider watches the /streams tab of the configured YouTube channels
+type Provider struct{}
 
-	if len(channelPic) < 2 {
-		return nil, fmt.Errorf("no channel pic found")
-	}
+func init() {
+	provider.Register(Provider{})
+}
+
+func (Provider) Name() string {
+	return "youtube"
+}
 
-	reChannelName := regexp.MustCompile(`ChannelName":"(.*?)"`)
-	channelName := reChannelName.FindStringSubmatch(string(body))
+func (Provider) Enabled() bool {
+	return config.Get().Archive.YouTube
+}
 
-	if len(channelName) < 2 {
-		return nil, fmt.Errorf("no channel name found")
+func (Provider) ListChannels() []provider.Channel {
+	var channels []provider.Channel
+	for _, channel := range config.Get().YouTubeChannel {
+		channels = append(channels, provider.Channel{
+			ID:         channel.ID,
+			Name:       channel.Name,
+			Filters:    channel.Filters,
+			OutPath:    channel.OutPath,
+			Downloader: channel.Downloader,
+			Interval:   time.Duration(channel.Interval) * time.Minute,
+			Options:    channel,
+		})
 	}
+	return channels
+}
 
-	dateCrawled := time.Now().UTC().Format(time.RFC3339Nano)
+func (Provider) CheckLive(ctx context.Context, channel provider.Channel) ([]*common.ChannelLive, error) {
+	options := channel.Options.(config.YouTubeChannel)
+	if options.Detection == "rss" {
+		return checkFeed(ctx, channel.ID, options.UseMemberCookies)
+	}
+	return GetChannelLives(ctx, channel.ID, options.UseMemberCookies)
+}
 
-	return &common.ChannelLive{
-		Title:          title[1],
-		ChannelID:      channelId[1],
-		ThumbnailUrl:   fmt.Sprintf("https://img.youtube.com/vi/%s/0.jpg", videoID),
-		VideoID:        videoID,
-		ChannelName:    channelName[1],
-		ChannelPicture: channelPic[1],
-		DateCrawled:    dateCrawled,
-	}, nil
+func (Provider) ShouldRecord(live *common.ChannelLive, channel provider.Channel) bool {
+	options := channel.Options.(config.YouTubeChannel)
+	return checkingLiveCondition(live, &options)
 }
 
-func CheckLiveAllChannel() {
-	for i, channel := range config.AppConfig.YouTubeChannel {
-		golog.Info("[youtube] checking live: ", channel.Name)
-		channelLive, err := GetChannelLive(channel.ID, channel.UseMemberCookies)
-		if err != nil {
-			golog.Error(err)
-		}
+func (p Provider) StartDownload(ctx context.Context, live *common.ChannelLive, channel provider.Channel) {
+	name := channel.Downloader
+	if name == "" {
+		name = "ytarchive"
+	}
+	args := []string{}
+	if live.Upcoming {
+		golog.Info("[youtube] waiting for upcoming live: ", live.VideoID, " scheduled at ", live.ScheduledStart.Format(time.RFC3339))
+		args = waitArgs[name]
+	}
+	downloader.StartDownload(ctx, name, p.WatchURL(live), args, live, channel.OutPath)
+}
 
-		if checkingLiveCondition(channelLive, &channel) {
-			discord.SendNotificationWebhook(channelLive.ChannelName, channelLive.Title, "https://www.youtube.com/watch?v="+channelLive.VideoID, channelLive.ThumbnailUrl, "Recording")
-			go func() {
-				ytarchive.StartDownload("https://www.youtube.com/watch?v="+channelLive.VideoID, []string{}, channelLive, channel.OutPath)
-			}()
-		}
-		if i < len(config.AppConfig.YouTubeChannel)-1 {
-			golog.Debug("[youtube] sleeping before checking next channel for ", config.AppConfig.Archive.Checker, "minutes")
-			time.Sleep(time.Duration(config.AppConfig.Archive.Checker) * time.Minute)
-		}
+func (Provider) WatchURL(live *common.ChannelLive) string {
+	return "https://www.youtube.com/watch?v=" + live.VideoID
+}
+
+func (Provider) LiveFromURL(u *url.URL) (*common.ChannelLive, error) {
+	if u.Host != "youtu.be" && u.Host != "youtube.com" && !strings.HasSuffix(u.Host, ".youtube.com") {
+		return nil, provider.ErrUnsupportedURL
+	}
+	videoID := ParseVideoID(u)
+	if videoID == nil {
+		return nil, fmt.Errorf("invalid YouTube URL")
 	}
+	return GetVideoDetailsFromID(*videoID)
 }
 
 func checkingLiveCondition(channelLive *common.ChannelLive, channel *config.YouTubeChannel) bool {
@@ -279,6 +257,11 @@ func checkingLiveCondition(channelLive *common.ChannelLive, channel *config.YouT
 		return false
 	}
 
+	if channelLive.Upcoming && !shouldPrearm(channelLive) {
+		golog.Debug("[youtube] upcoming live is not within the prearm window: ", channel.Name, " - ", channelLive.VideoID)
+		return false
+	}
+
 	if channelLive.MembersOnly && !channel.UseMemberCookies {
 		golog.Debug("[youtube] live is members only, but not using member cookies: ", channel.Name)
 		return false
@@ -294,6 +277,23 @@ func checkingLiveCondition(channelLive *common.ChannelLive, channel *config.YouT
 	return false
 }
 
+// shouldPrearm reports whether an upcoming stream should be recorded already,
+// the downloader then waits for it to start
+func shouldPrearm(live *common.ChannelLive) bool {
+	if !config.Get().Archive.PrearmUpcoming {
+		return false
+	}
+	// Streams without a start time are waiting rooms that may never go live
+	if live.ScheduledStart.IsZero() {
+		return false
+	}
+	window := time.Duration(config.Get().Archive.PrearmMinutes) * time.Minute
+	if window <= 0 {
+		window = 15 * time.Minute
+	}
+	return time.Until(live.ScheduledStart) <= window
+}
+
 func ParseVideoID(parsedURI *url.URL) *string {
 	host := parsedURI.Host
 	path := parsedURI.Path